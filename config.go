@@ -8,21 +8,41 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"scrambles/engine"
 )
 
+// config.txt starts with its version. files from before it was written are version 1
+const CONFIG_VERSION = 1
+
+var configMigrations = [CONFIG_VERSION - 1]engine.Migration{}
+
 type Config struct {
     WordListFile string
     TilesFontFile string
     UiFontFile string
+    BoardLayoutFile string
+    TileSetFile string
+    ReplayFile string
     PlayerTypesArr [4]string
     GameMode string
+    ChallengeRule string
+    ChallengePointsInt int
+    ClockMode string
     TimeLimitSecondsInt int
+    IncrementSecondsInt int
+    OvertimePenaltyInt int
+    ScorelessTurnsInt int
+    RackSizeInt int
+    SeedInt int // 0 picks a new seed for every game
 }
 
 type Assets struct {
     WordList []string
     TilesFont []byte
     UiFont []byte
+    BoardLayout []string
+    TileSet []string
+    Replay []string
 }
 
 func loadFile(fileName string) ([]byte, error) {
@@ -47,9 +67,20 @@ func makeDefaultConfig() Config {
         "assets/all-words.txt",
         "assets/Cantarell_700Bold.ttf",
         "assets/Cabin-SemiBold.ttf",
+        "",
+        "",
+        "",
         [4]string{"real", "real", "none", "none"},
         "classic",
+        "double",
+        5,
+        "turn",
         120,
+        10,
+        10,
+        6,
+        7,
+        0,
     }
 }
 
@@ -58,6 +89,7 @@ func saveConfig(config *Config) {
     configType := configFields.Type()
     nConfigKeys := configFields.NumField()
     var builder strings.Builder
+    builder.WriteString("Version " + strconv.Itoa(CONFIG_VERSION) + "\n")
 
     for i := 0; i < nConfigKeys; i++ {
         field := configFields.Field(i)
@@ -101,16 +133,31 @@ func loadConfig() (config Config, assets Assets, err error) {
         assetsKeys[assetsType.Field(i).Name] = i
     }
 
+    // start from the defaults so that settings missing from an older config.txt still have sensible values
+    config = makeDefaultConfig()
+
     configData, err := loadFile("config.txt")
     if configData != nil {
         lines := strings.Split(string(configData), "\n")
+        version, err := engine.FindVersion(lines, "Version ")
+        if err != nil {
+            return Config{}, Assets{}, err
+        }
+        lines, err = engine.Migrate("config.txt", lines, max(version, 1), configMigrations[:])
+        if err != nil {
+            return Config{}, Assets{}, err
+        }
+
         for _, l := range lines {
             idx := strings.IndexByte(l, ' ')
             if idx <= 0 {
                 continue
             }
             name := l[:idx]
-            configIdx := configKeys[name]
+            configIdx, exists := configKeys[name]
+            if !exists {
+                continue
+            }
 
             if strings.Contains(name, "Arr") {
                 values := strings.Split(l[idx+1:], " ")
@@ -136,7 +183,6 @@ func loadConfig() (config Config, assets Assets, err error) {
             }
         }
     } else {
-        config = makeDefaultConfig()
         saveConfig(&config)
     }
 
@@ -145,6 +191,10 @@ func loadConfig() (config Config, assets Assets, err error) {
         name := assetName + "File"
         configIdx := configKeys[name]
         fileName := configFields.Field(configIdx).Interface().(string)
+        if fileName == "" && (assetName == "BoardLayout" || assetName == "TileSet" || assetName == "Replay") {
+            // optional, the built-in layout and tiles are used instead, and a game record is only needed to replay it
+            continue
+        }
         data, err := loadFile(fileName)
         if data == nil {
             err = errors.New("Failed to open " + name + " \"" + fileName + "\"")
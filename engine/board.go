@@ -0,0 +1,438 @@
+package engine
+
+import "errors"
+import "strconv"
+import "strings"
+
+const NORMAL = 0
+const DOUBLE_WORD = 1
+const TRIPLE_WORD = 2
+const DOUBLE_LETTER = 3
+const TRIPLE_LETTER = 4
+const ALL_LETTERS = 5 // every letter of every word through this square is doubled
+const QUAD_WORD = 6
+const QUAD_LETTER = 7
+
+const MIN_BOARD_SIZE = 7
+const MAX_BOARD_SIZE = 21
+
+const ALL_TILES_BONUS = 50
+
+const PLACE_OK = 0
+const PLACE_OFF_BOARD = 1
+const PLACE_OCCUPIED = 2
+const PLACE_NOT_IN_LINE = 3
+const PLACE_HAS_GAPS = 4
+const PLACE_MISSES_CENTRE = 5
+const PLACE_NOT_CONNECTED = 6
+const PLACE_NO_WORDS = 7
+
+var PlacementErrorStrings = [...]string {
+    "",
+    "The tiles must all fit on the board",
+    "A tile can only be placed on an empty square",
+    "The tiles must be placed in a single row or column",
+    "The tiles must form one unbroken line",
+    "The first word must cover the centre square",
+    "New tiles must touch a tile already on the board",
+    "The tiles must form at least one word",
+}
+
+// indexed by square type. '*' marks the start square, which is a double word square
+const boardLayoutCodes = ".DTdtaQq"
+
+var boardTileTypeLookup = [...]int32 {
+    2, 0, 0, 3, 0, 0, 0, 2,
+    0, 1, 0, 0, 0, 4, 0, 0,
+    0, 0, 1, 0, 0, 0, 3, 0,
+    3, 0, 0, 1, 0, 0, 0, 3,
+    0, 0, 0, 0, 1, 0, 0, 0,
+    0, 4, 0, 0, 0, 4, 0, 0,
+    0, 0, 3, 0, 0, 0, 3, 0,
+}
+
+// squares are stored row by row. Start is the square that the first word has to cover
+type Layout struct {
+    Size int
+    Squares []int32
+    Start int
+}
+
+// start and end are board cell indices. Tiles are the tiles that spell the word, as they are on the board
+type Word struct {
+    Text string
+    Tiles []int16
+    Start int
+    End int
+    IsValid bool
+}
+
+// IsMultiplier steps multiply a letter or a word by Value, the others add Value points
+type ScoreStep struct {
+    Pos int
+    Value int32
+    IsMultiplier bool
+}
+
+// the built-in 15x15 layout is stored as one corner of the board and folded through 4-way symmetry
+func getDefaultTileType(x, y int32) int32 {
+    if x < 0 || y < 0 || x >= 15 || y >= 15 {
+        return NORMAL
+    }
+    if x == 7 && y == 7 {
+        return DOUBLE_WORD
+    }
+
+    if x >= 7 && y >= 8 {
+        x = 14 - x
+        y = 14 - y
+    } else if x >= 8 && y <= 7 {
+        temp := x
+        x = y
+        y = 14 - temp
+    } else if x <= 7 && y >= 7 {
+        temp := x
+        x = 14 - y
+        y = temp
+    }
+
+    return boardTileTypeLookup[x + 8 * y]
+}
+
+func MakeDefaultLayout() Layout {
+    layout := Layout{15, make([]int32, 15 * 15), 7 + 15 * 7}
+    for y := int32(0); y < 15; y++ {
+        for x := int32(0); x < 15; x++ {
+            layout.Squares[x + 15 * y] = getDefaultTileType(x, y)
+        }
+    }
+    return layout
+}
+
+// each row of the board is one line of premium codes, and '*' marks the square that the first word has to cover.
+// the board has to be square, and if there's no '*' the first word goes through the middle
+func ParseBoardLayout(lines []string) (Layout, error) {
+    squares := make([]int32, 0, MAX_BOARD_SIZE * MAX_BOARD_SIZE)
+    startSquare := -1
+    size := 0
+    nRows := 0
+
+    for _, l := range lines {
+        l = strings.TrimSpace(l)
+        if len(l) == 0 || l[0] == '#' {
+            continue
+        }
+
+        nCols := 0
+        for i := 0; i < len(l); i++ {
+            if l[i] == ' ' || l[i] == '\t' {
+                continue
+            }
+            idx := strings.IndexByte(boardLayoutCodes, l[i])
+            if l[i] == '*' {
+                startSquare = len(squares)
+                idx = DOUBLE_WORD
+            }
+            if idx < 0 {
+                return Layout{}, errors.New("unknown square \"" + l[i:i+1] + "\" in board layout, row " + strconv.Itoa(nRows + 1))
+            }
+            squares = append(squares, int32(idx))
+            nCols++
+        }
+        if nRows == 0 {
+            size = nCols
+            if size < MIN_BOARD_SIZE || size > MAX_BOARD_SIZE {
+                return Layout{}, errors.New("the board layout should be between " + strconv.Itoa(MIN_BOARD_SIZE) + " and " + strconv.Itoa(MAX_BOARD_SIZE) + " squares wide, not " + strconv.Itoa(size))
+            }
+        } else if nCols != size {
+            return Layout{}, errors.New("row " + strconv.Itoa(nRows + 1) + " of the board layout has " + strconv.Itoa(nCols) + " squares, not " + strconv.Itoa(size))
+        }
+        nRows++
+    }
+
+    if nRows != size || size == 0 {
+        return Layout{}, errors.New("the board layout has " + strconv.Itoa(nRows) + " rows, not " + strconv.Itoa(size))
+    }
+    if startSquare < 0 {
+        startSquare = (size / 2) + size * (size / 2)
+    }
+    return Layout{size, squares, startSquare}, nil
+}
+
+func (e *Engine) GetSquareType(x, y int32) int32 {
+    size := int32(e.Layout.Size)
+    if x < 0 || y < 0 || x >= size || y >= size {
+        return NORMAL
+    }
+    return e.Layout.Squares[x + size * y]
+}
+
+func (e *Engine) isBoardEmpty() bool {
+    for i := 0; i < len(e.Board); i++ {
+        if e.Board[i] != 0 {
+            return false
+        }
+    }
+    return true
+}
+
+// positions are board cell indices. the board must not contain the new tiles yet
+func (e *Engine) CheckPlacement(positions []int) int32 {
+    size := e.Layout.Size
+    n := 0
+    minPos := size * size
+    maxPos := -1
+    for _, pos := range positions {
+        if pos < 0 || pos >= size * size {
+            return PLACE_OFF_BOARD
+        }
+        if e.Board[pos] != 0 {
+            return PLACE_OCCUPIED
+        }
+        for _, other := range positions[:n] {
+            if other == pos {
+                return PLACE_OCCUPIED
+            }
+        }
+        minPos = min(minPos, pos)
+        maxPos = max(maxPos, pos)
+        n++
+    }
+    if n == 0 {
+        return PLACE_NO_WORDS
+    }
+
+    inc := 1
+    if minPos % size == maxPos % size {
+        inc = size
+    } else if minPos / size != maxPos / size {
+        return PLACE_NOT_IN_LINE
+    }
+    // the ends being in line isn't enough, since the tiles in between could still wander off it
+    for _, pos := range positions {
+        if (inc == 1 && pos / size != minPos / size) || (inc == size && pos % size != minPos % size) {
+            return PLACE_NOT_IN_LINE
+        }
+    }
+
+    coversCentre := false
+    for pos := minPos; pos <= maxPos; pos += inc {
+        isNewTile := false
+        for _, p := range positions {
+            if p == pos {
+                isNewTile = true
+                break
+            }
+        }
+        if !isNewTile && e.Board[pos] == 0 {
+            return PLACE_HAS_GAPS
+        }
+        if pos == e.Layout.Start {
+            coversCentre = true
+        }
+    }
+
+    // every position from here on is in a single line with no gaps, so any neighbouring tile is part of a word
+    if e.isBoardEmpty() {
+        if !coversCentre {
+            return PLACE_MISSES_CENTRE
+        }
+        if n < 2 {
+            return PLACE_NO_WORDS
+        }
+        return PLACE_OK
+    }
+
+    for _, pos := range positions {
+        x := pos % size
+        y := pos / size
+        if (x > 0 && e.Board[pos-1] != 0) || (x < size-1 && e.Board[pos+1] != 0) ||
+            (y > 0 && e.Board[pos-size] != 0) || (y < size-1 && e.Board[pos+size] != 0) {
+            return PLACE_OK
+        }
+    }
+
+    return PLACE_NOT_CONNECTED
+}
+
+// includes the new tiles, so that words can be found before the tiles are placed
+func (e *Engine) getTileAt(pos int, tiles []int16, positions []int) int16 {
+    if e.Board[pos] != 0 {
+        return e.Board[pos]
+    }
+    for i := 0; i < len(positions); i++ {
+        if positions[i] == pos {
+            return tiles[i]
+        }
+    }
+    return 0
+}
+
+// each line is stored as its start cell plus its end cell times the number of cells on the board
+func (e *Engine) findLines(tiles []int16, positions []int, lines []uint32) []uint32 {
+    lines = lines[0:0]
+    size := e.Layout.Size
+
+    for _, pos := range positions {
+        if pos < 0 || pos >= size * size {
+            continue
+        }
+        x := pos % size
+        y := pos / size
+
+        for d := 0; d < 4; d++ {
+            dx := ((d & 2) - 1) * (d & 1)
+            dy := (((d+1) & 2) - 1) * ((d+1) & 1)
+            if x + dx < 0 || x + dx >= size || y + dy < 0 || y + dy >= size {
+                continue
+            }
+
+            pos2 := (x + dx) + size * (y + dy)
+            if e.getTileAt(pos2, tiles, positions) == 0 {
+                continue
+            }
+
+            // look in opposite direction for both ends until there is no tile at that position or its off the board
+            xx := x - dx
+            yy := y - dy
+            for xx >= 0 && xx < size && yy >= 0 && yy < size && e.getTileAt(xx + size * yy, tiles, positions) != 0 {
+                xx -= dx
+                yy -= dy
+            }
+            start := (xx + dx) + size * (yy + dy)
+
+            xx = x + dx
+            yy = y + dy
+            for xx >= 0 && xx < size && yy >= 0 && yy < size && e.getTileAt(xx + size * yy, tiles, positions) != 0 {
+                xx += dx
+                yy += dy
+            }
+            end := (xx - dx) + size * (yy - dy)
+
+            line := uint32(min(start, end) + (size * size * max(start, end)))
+            exists := false
+            for j := 0; j < len(lines); j++ {
+                if lines[j] == line {
+                    exists = true
+                    break
+                }
+            }
+
+            if !exists {
+                lines = append(lines, line)
+            }
+        }
+    }
+
+    return lines
+}
+
+func (e *Engine) getLineEnds(line uint32) (start, end, inc int) {
+    size := e.Layout.Size
+    start = int(line) % (size * size)
+    end   = int(line) / (size * size)
+    inc = 1
+    if start % size == end % size {
+        inc = size
+    }
+    return start, end, inc
+}
+
+// FindWords lists the words that the tiles would make at the given positions, without touching the board
+func (e *Engine) FindWords(tiles []int16, positions []int) []Word {
+    words, _, _ := e.evaluate(tiles, positions)
+    return words
+}
+
+// Score works out what the tiles would score at the given positions, along with each step of the sum so it can be shown
+func (e *Engine) Score(tiles []int16, positions []int) (int32, []ScoreStep) {
+    _, score, steps := e.evaluate(tiles, positions)
+    return score, steps
+}
+
+// unlike the other premium squares, an all letters square keeps counting after the turn a tile is placed on it,
+// for every new word that runs through it
+func (e *Engine) coversAllLetters(start, end, inc int) bool {
+    size := int32(e.Layout.Size)
+    for pos := start; pos <= end; pos += inc {
+        if e.GetSquareType(int32(pos) % size, int32(pos) / size) == ALL_LETTERS {
+            return true
+        }
+    }
+    return false
+}
+
+func (e *Engine) evaluate(tiles []int16, positions []int) (words []Word, totalScore int32, steps []ScoreStep) {
+    e.lines = e.findLines(tiles, positions, e.lines)
+    size := int32(e.Layout.Size)
+    var builder strings.Builder
+
+    for _, line := range e.lines {
+        start, end, inc := e.getLineEnds(line)
+
+        builder.Reset()
+        word := Word{"", nil, start, end, false}
+        wordScore := int32(0)
+        wordMultipliers := e.wordMultipliers[0:0]
+        doublesAllLetters := e.coversAllLetters(start, end, inc)
+
+        for pos := start; pos <= end; pos += inc {
+            isNewTile := false
+            for _, p := range positions {
+                if p == pos {
+                    isNewTile = true
+                    break
+                }
+            }
+
+            tile := e.getTileAt(pos, tiles, positions)
+            builder.WriteString(e.Lexicon.GetLetter(tile))
+            word.Tiles = append(word.Tiles, tile)
+
+            letterScore := e.GetPoints(tile)
+            steps = append(steps, ScoreStep{pos, letterScore, false})
+
+            if isNewTile {
+                tt := e.GetSquareType(int32(pos) % size, int32(pos) / size)
+                multiplier := int32(2)
+                if tt == QUAD_LETTER || tt == QUAD_WORD {
+                    multiplier = 4
+                } else if tt == TRIPLE_LETTER || tt == TRIPLE_WORD {
+                    multiplier = 3
+                }
+                if tt == DOUBLE_LETTER || tt == TRIPLE_LETTER || tt == QUAD_LETTER {
+                    steps = append(steps, ScoreStep{pos, multiplier, true})
+                    letterScore *= multiplier
+                } else if tt == DOUBLE_WORD || tt == TRIPLE_WORD || tt == QUAD_WORD {
+                    wordMultipliers = append(wordMultipliers, ScoreStep{pos, multiplier, true})
+                }
+            }
+            if doublesAllLetters {
+                steps = append(steps, ScoreStep{pos, 2, true})
+                letterScore *= 2
+            }
+
+            wordScore += letterScore
+        }
+
+        // word multipliers are applied once the whole word has been added up, last one first
+        for j := len(wordMultipliers) - 1; j >= 0; j-- {
+            steps = append(steps, wordMultipliers[j])
+            wordScore *= wordMultipliers[j].Value
+        }
+        e.wordMultipliers = wordMultipliers
+
+        word.Text = builder.String()
+        word.IsValid = e.Lexicon.ContainsTiles(word.Tiles)
+        words = append(words, word)
+        totalScore += wordScore
+    }
+
+    // the bonus is the same whatever the size of the rack
+    if len(positions) >= e.RackSize && len(words) > 0 {
+        steps = append(steps, ScoreStep{positions[len(positions) - 1], ALL_TILES_BONUS, false})
+        totalScore += ALL_TILES_BONUS
+    }
+
+    return words, totalScore, steps
+}
@@ -0,0 +1,136 @@
+package engine
+
+import "testing"
+
+func TestCheckPlacement(t *testing.T) {
+    e := makeTestEngine(t)
+    cases := []struct {
+        positions []int
+        reason int32
+    }{
+        {[]int{112, 113}, PLACE_OK},
+        {[]int{97, 112, 127}, PLACE_OK},
+        {[]int{}, PLACE_NO_WORDS},
+        {[]int{112}, PLACE_NO_WORDS},
+        {[]int{100, 101}, PLACE_MISSES_CENTRE},
+        {[]int{111, 113}, PLACE_HAS_GAPS},
+        {[]int{112, 128}, PLACE_NOT_IN_LINE},
+        {[]int{112, 112}, PLACE_OCCUPIED},
+        {[]int{224, 225}, PLACE_OFF_BOARD},
+        {[]int{-1, 0}, PLACE_OFF_BOARD},
+    }
+    for _, c := range cases {
+        if reason := e.CheckPlacement(c.positions); reason != c.reason {
+            t.Errorf("on an empty board %v should give %d, got %d", c.positions, c.reason, reason)
+        }
+    }
+
+    e.Board[112] = makeTiles(t, e, "A")[0]
+    cases = []struct {
+        positions []int
+        reason int32
+    }{
+        {[]int{113}, PLACE_OK},
+        {[]int{111, 113}, PLACE_OK},
+        {[]int{97, 127}, PLACE_OK},
+        {[]int{112, 113}, PLACE_OCCUPIED},
+        {[]int{0, 1}, PLACE_NOT_CONNECTED},
+        {[]int{110, 113}, PLACE_HAS_GAPS},
+    }
+    for _, c := range cases {
+        if reason := e.CheckPlacement(c.positions); reason != c.reason {
+            t.Errorf("with a tile on H8 %v should give %d, got %d", c.positions, c.reason, reason)
+        }
+    }
+}
+
+func TestCheckPlacementRejectsLShape(t *testing.T) {
+    e := makeTestEngine(t, "TCAT")
+    e.Board[112] = makeTiles(t, e, "A")[0]
+
+    // H6, H7 and H9 run down through the A on H8, but I7 sticks out to the side
+    reason := e.CheckPlacement([]int{82, 97, 127, 98})
+    if reason != PLACE_NOT_IN_LINE {
+        t.Fatalf("an L-shaped play should be rejected, got %d", reason)
+    }
+
+    setRack(t, e, 0, "TCTTEEE")
+    _, err := e.Apply(Move{MOVE_PLAY, 0, makeTiles(t, e, "TCTT"), []int{82, 97, 127, 98}})
+    if err == nil {
+        t.Fatal("Apply should refuse an L-shaped play")
+    }
+}
+
+func TestPremiumScoring(t *testing.T) {
+    e := makeTestEngine(t)
+    cases := []struct {
+        letters string
+        positions []int
+        score int32
+    }{
+        {"CAT", []int{111, 112, 113}, 10},        // double word on the start square
+        {"CAT", []int{108, 109, 110}, 8},         // double letter on D8
+        {"CAT", []int{80, 81, 82}, 11},           // triple letter on F6
+        {"CAT", []int{0, 1, 2}, 15},              // triple word on A1
+        {"CATS", []int{0, 1, 2, 3}, 21},          // the double letter on D1 counts before the triple word
+        {"CAT", []int{16, 31, 46}, 10},           // double word on B2, going down
+        {"cAT", []int{111, 112, 113}, 4},         // a blank is worth nothing, even on a premium square
+        {"CATSEAT", []int{105, 106, 107, 108, 109, 110, 111}, 80}, // (10 with the double letter) * 3 + 50 for using every tile
+    }
+    for _, c := range cases {
+        score, _ := e.Score(makeTiles(t, e, c.letters), c.positions)
+        if score != c.score {
+            t.Errorf("%s on %v should score %d, got %d", c.letters, c.positions, c.score, score)
+        }
+    }
+
+    // premium squares that are already covered don't count again
+    e.Board[0] = makeTiles(t, e, "C")[0]
+    score, steps := e.Score(makeTiles(t, e, "AT"), []int{1, 2})
+    if score != 5 {
+        t.Errorf("AT after the C on A1 should score 5, got %d", score)
+    }
+    for _, step := range steps {
+        if step.IsMultiplier {
+            t.Errorf("there shouldn't be any multipliers, got %v", step)
+        }
+    }
+}
+
+func TestAllLettersSquareDoublesCrossingWords(t *testing.T) {
+    e := makeTestEngine(t, "CAT", "ACE", "TA")
+    layout, err := ParseBoardLayout([]string{
+        ".......",
+        ".......",
+        ".......",
+        "..a*...",
+        ".......",
+        ".......",
+        ".......",
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    e.SetLayout(layout)
+
+    // C on the all letters square and T on the start square: (3 + 1 + 1) * 2 * 2
+    setRack(t, e, 0, "CATEEEE")
+    res := play(t, e, 0, "CAT", 23, 24, 25)
+    if res.Score != 20 {
+        t.Errorf("CAT should score 20, got %d", res.Score)
+    }
+
+    // ACE goes down through the C that's already on the square, and is still doubled
+    setRack(t, e, 1, "AEIIIII")
+    res = play(t, e, 1, "AE", 16, 30)
+    if res.Score != 10 {
+        t.Errorf("ACE should score 10, got %d", res.Score)
+    }
+
+    // TA doesn't go through the square
+    setRack(t, e, 0, "AEEEEEE")
+    res = play(t, e, 0, "A", 32)
+    if res.Score != 2 {
+        t.Errorf("TA should score 2, got %d", res.Score)
+    }
+}
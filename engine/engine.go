@@ -0,0 +1,417 @@
+// Package engine holds the rules of the game: the board, the bag, the racks, the scores and whose turn it is.
+// it doesn't know anything about windows, input or animation, so a front end drives it by applying moves.
+package engine
+
+import "errors"
+import "strconv"
+
+const MOVE_PLAY = 0
+const MOVE_EXCHANGE = 1
+const MOVE_PASS = 2
+const MOVE_CHALLENGE = 3
+
+// events carry on from the move kinds, for the changes to the scores that aren't moves
+const EVENT_WITHDRAWN = 4
+const EVENT_CHALLENGE_PENALTY = 5
+const EVENT_END_RACK = 6
+const EVENT_TIME_PENALTY = 7
+
+const CHALLENGE_SINGLE = 0
+const CHALLENGE_DOUBLE = 1
+const CHALLENGE_POINTS = 2
+
+// for a play, Tiles[i] goes on board cell Positions[i], with BLANK_FLAG set on a blank that stands for a letter.
+// for an exchange, Tiles are the tiles going back in the bag. a challenge is made by Player against the last play
+type Move struct {
+    Kind int32
+    Player int32
+    Tiles []int16
+    Positions []int
+}
+
+type Result struct {
+    Score int32
+    Words []Word
+    Steps []ScoreStep
+    Drawn []int16
+    Returned []int16 // tiles that went back on the rack because a challenge took the play off the board
+    IsUpheld bool
+    Penalty int32
+    Skipped int32 // the player that lost their turn, or -1
+    IsGameOver bool
+}
+
+// one line of the game record. Rack is what the player held beforehand, Score is how much their score changed by and
+// Total is their score afterwards. for the end of the game, Move.Tiles are the tiles left on the racks that the points are for
+type Event struct {
+    Kind int32
+    Player int32
+    Rack []int16
+    Move Move
+    Notation string
+    Score int32
+    Total int32
+}
+
+// what's needed to take the last play back off the board if it's challenged
+type lastPlay struct {
+    isChallengeable bool
+    player int32
+    event int
+    tiles []int16
+    positions []int
+    drawn []int16
+    words []Word
+    score int32
+    scorelessTurns int32
+    skipNextTurn [4]bool
+    endedGame bool
+}
+
+type Engine struct {
+    TileSet []Tile
+    BlankTile int16
+    Layout Layout
+    Lexicon Lexicon
+
+    Board []int16
+    Bag []int16
+    Racks [4][]int16
+    Scores [4]int32
+    EndAdjustments [4]int32
+    ChallengePenalties [4]int32
+    TimePenalties [4]int32
+    IsActive [4]bool
+    SkipNextTurn [4]bool
+    Turn int32
+    ScorelessTurns int32
+    IsOver bool
+    History []Event
+
+    RackSize int
+    ScorelessTurnLimit int
+    ChallengeRule int32
+    ChallengePoints int32
+    ShouldValidate bool
+
+    // the tiles drawn only depend on the seed and how many tiles have been drawn since Reset,
+    // so the same seed and the same moves always give the same game
+    Seed uint64
+    nDraws uint64
+
+    last lastPlay
+    lines []uint32
+    wordMultipliers []ScoreStep
+}
+
+func (e *Engine) Init(wordsList []string, tileSet []Tile) {
+    e.TileSet = tileSet
+    e.BlankTile = int16(len(tileSet))
+    e.Lexicon = MakeLexicon(wordsList, tileSet)
+    e.SetLayout(MakeDefaultLayout())
+    e.RackSize = 7
+}
+
+// also clears the board, since the old tiles won't line up with the new squares
+func (e *Engine) SetLayout(layout Layout) {
+    e.Layout = layout
+    e.Board = make([]int16, layout.Size * layout.Size)
+}
+
+// empties the board and the racks and puts every tile back in the bag, ready for the players to draw for the first turn
+func (e *Engine) Reset() {
+    for i := 0; i < len(e.Board); i++ {
+        e.Board[i] = 0
+    }
+    e.fillBag()
+    for i := 0; i < 4; i++ {
+        e.Racks[i] = e.Racks[i][0:0]
+        e.Scores[i] = 0
+        e.EndAdjustments[i] = 0
+        e.ChallengePenalties[i] = 0
+        e.TimePenalties[i] = 0
+        e.SkipNextTurn[i] = false
+    }
+    e.Turn = 0
+    e.ScorelessTurns = 0
+    e.IsOver = false
+    e.History = nil
+    e.nDraws = 0
+    e.last = lastPlay{}
+}
+
+// fills every active player's rack and starts the game with the given player
+func (e *Engine) Deal(first int32) {
+    for i := int32(0); i < 4; i++ {
+        if e.IsActive[i] {
+            e.fillRack(i)
+        }
+    }
+    e.Turn = first
+}
+
+func (e *Engine) NextPlayer(playerIdx int32) int32 {
+    next := playerIdx
+    for i := 0; i < 4; i++ {
+        next = (next + 1) % 4
+        if e.IsActive[next] {
+            return next
+        }
+    }
+    return playerIdx
+}
+
+func (e *Engine) Apply(move Move) (Result, error) {
+    res := Result{Skipped: -1}
+    // a play that goes out ends the game straight away, but it can still be challenged
+    if e.IsOver && (move.Kind != MOVE_CHALLENGE || !e.last.isChallengeable) {
+        return res, errors.New("The game is over")
+    }
+    if move.Player < 0 || move.Player >= 4 || !e.IsActive[move.Player] {
+        return res, errors.New("There is no player " + strconv.Itoa(int(move.Player) + 1))
+    }
+    if move.Kind == MOVE_CHALLENGE {
+        return e.challenge(move.Player)
+    }
+    if move.Player != e.Turn {
+        return res, errors.New("It isn't player " + strconv.Itoa(int(move.Player) + 1) + "'s turn")
+    }
+
+    if move.Kind == MOVE_PLAY {
+        return e.play(move)
+    }
+
+    rack := append([]int16(nil), e.Racks[move.Player]...)
+    if move.Kind == MOVE_EXCHANGE {
+        if len(move.Tiles) == 0 {
+            return res, errors.New("Choose at least one tile to exchange")
+        }
+        if len(e.Bag) < e.RackSize {
+            return res, errors.New("Tiles can only be exchanged while the bag has at least " + strconv.Itoa(e.RackSize) + " tiles")
+        }
+        if !e.hasTiles(move.Player, move.Tiles) {
+            return res, errors.New("Those tiles aren't on the rack")
+        }
+
+        // new tiles are drawn before the old ones go back in the bag
+        for _, tile := range move.Tiles {
+            e.removeFromRack(move.Player, tile)
+        }
+        res.Drawn = e.fillRack(move.Player)
+        for _, tile := range move.Tiles {
+            e.PutTile(tile)
+        }
+    } else if move.Kind != MOVE_PASS {
+        return res, errors.New("Unknown move")
+    }
+
+    e.last = lastPlay{}
+    e.ScorelessTurns++
+    e.record(move.Kind, move.Player, rack, move, 0)
+    e.endTurn(move.Player, &res)
+    return res, nil
+}
+
+func (e *Engine) play(move Move) (Result, error) {
+    res := Result{Skipped: -1}
+    if len(move.Tiles) != len(move.Positions) {
+        return res, errors.New("Every tile needs a position")
+    }
+    if !e.hasTiles(move.Player, move.Tiles) {
+        return res, errors.New("Those tiles aren't on the rack")
+    }
+    for _, tile := range move.Tiles {
+        if tile == e.BlankTile {
+            return res, errors.New("Every blank needs to be given a letter")
+        }
+    }
+    reason := e.CheckPlacement(move.Positions)
+    if reason != PLACE_OK {
+        return res, errors.New(PlacementErrorStrings[reason])
+    }
+
+    words, score, steps := e.evaluate(move.Tiles, move.Positions)
+    if e.ShouldValidate {
+        rejected := ""
+        for _, w := range words {
+            if !w.IsValid {
+                if len(rejected) > 0 {
+                    rejected += ", "
+                }
+                rejected += w.Text
+            }
+        }
+        if len(rejected) > 0 {
+            return res, errors.New("Not valid: " + rejected)
+        }
+    }
+
+    rack := append([]int16(nil), e.Racks[move.Player]...)
+    e.last = lastPlay{true, move.Player, len(e.History), nil, nil, nil, words, score, e.ScorelessTurns, e.SkipNextTurn, false}
+    e.last.tiles = append(e.last.tiles, move.Tiles...)
+    e.last.positions = append(e.last.positions, move.Positions...)
+
+    for i, pos := range move.Positions {
+        e.Board[pos] = move.Tiles[i]
+        e.removeFromRack(move.Player, move.Tiles[i])
+    }
+    res.Drawn = e.fillRack(move.Player)
+    e.last.drawn = res.Drawn
+
+    e.Scores[move.Player] += score
+    e.ScorelessTurns = 0
+    e.record(MOVE_PLAY, move.Player, rack, move, score)
+    res.Score = score
+    res.Words = words
+    res.Steps = steps
+
+    if len(e.Bag) == 0 && len(e.Racks[move.Player]) == 0 {
+        e.finish(move.Player)
+        e.last.endedGame = true
+        res.IsGameOver = true
+        return res, nil
+    }
+    e.endTurn(move.Player, &res)
+    return res, nil
+}
+
+// the game ends once too many turns in a row have gone by without a score
+func (e *Engine) endTurn(playerIdx int32, res *Result) {
+    if e.ScorelessTurnLimit > 0 && int(e.ScorelessTurns) >= e.ScorelessTurnLimit {
+        e.finish(-1)
+        res.IsGameOver = true
+        return
+    }
+
+    next := e.NextPlayer(playerIdx)
+    if e.SkipNextTurn[next] && next != playerIdx {
+        // a lost turn goes in the record as a pass
+        e.SkipNextTurn[next] = false
+        e.ScorelessTurns++
+        e.record(MOVE_PASS, next, e.Racks[next], Move{MOVE_PASS, next, nil, nil}, 0)
+        res.Skipped = next
+        next = e.NextPlayer(next)
+    }
+    e.Turn = next
+}
+
+func (e *Engine) challenge(challenger int32) (Result, error) {
+    res := Result{Skipped: -1}
+    if !e.last.isChallengeable {
+        return res, errors.New("There is no play to challenge")
+    }
+    if challenger == e.last.player {
+        return res, errors.New("Players can't challenge their own play")
+    }
+    e.last.isChallengeable = false
+
+    for _, w := range e.last.words {
+        if !w.IsValid {
+            res.Words = append(res.Words, w)
+        }
+    }
+
+    if len(res.Words) > 0 {
+        p := e.last.player
+        res.IsUpheld = true
+        if e.last.endedGame {
+            e.unfinish()
+        }
+        // the turn is worked out again from the withdrawn play, so a turn that was skipped after it comes after the withdrawal
+        e.History = e.History[:e.last.event + 1]
+        e.SkipNextTurn = e.last.skipNextTurn
+
+        for _, tile := range e.last.drawn {
+            e.removeFromRack(p, tile)
+            e.PutTile(tile)
+        }
+        for i, pos := range e.last.positions {
+            e.Board[pos] = 0
+            tile := e.last.tiles[i]
+            if (tile & BLANK_FLAG) != 0 {
+                tile = e.BlankTile
+            }
+            e.Racks[p] = append(e.Racks[p], tile)
+            res.Returned = append(res.Returned, tile)
+        }
+        e.Scores[p] -= e.last.score
+        e.ScorelessTurns = e.last.scorelessTurns + 1
+        e.record(EVENT_WITHDRAWN, p, e.History[e.last.event].Rack, Move{EVENT_WITHDRAWN, p, nil, nil}, -e.last.score)
+        e.endTurn(p, &res)
+        res.IsGameOver = e.IsOver
+        return res, nil
+    }
+
+    if e.ChallengeRule == CHALLENGE_DOUBLE {
+        // if it's already the challenger's turn, it's the one they lose. the turn goes on from them rather than from
+        // the play, since a turn that was skipped after the play has already been taken
+        if e.Turn == challenger && !e.IsOver {
+            e.ScorelessTurns++
+            e.record(MOVE_PASS, challenger, e.Racks[challenger], Move{MOVE_PASS, challenger, nil, nil}, 0)
+            res.Skipped = challenger
+            e.Turn = e.NextPlayer(challenger)
+        } else {
+            e.SkipNextTurn[challenger] = true
+        }
+    } else if e.ChallengeRule == CHALLENGE_POINTS {
+        res.Penalty = e.ChallengePoints
+        e.Scores[challenger] -= res.Penalty
+        e.ChallengePenalties[challenger] += res.Penalty
+        e.record(EVENT_CHALLENGE_PENALTY, challenger, e.Racks[challenger], Move{EVENT_CHALLENGE_PENALTY, challenger, nil, nil}, -res.Penalty)
+    }
+    res.IsGameOver = e.IsOver
+    return res, nil
+}
+
+// outIdx is the player who used up all of their tiles, or -1 if the game ended after too many scoreless turns
+func (e *Engine) finish(outIdx int32) {
+    unplayedTotal := int32(0)
+    var unplayed []int16
+    for i := int32(0); i < 4; i++ {
+        if !e.IsActive[i] || i == outIdx {
+            continue
+        }
+        value := e.GetRackValue(i)
+        e.EndAdjustments[i] = -value
+        e.Scores[i] -= value
+        unplayedTotal += value
+        unplayed = append(unplayed, e.Racks[i]...)
+        e.record(EVENT_END_RACK, i, e.Racks[i], Move{EVENT_END_RACK, i, e.Racks[i], nil}, -value)
+    }
+    if outIdx >= 0 {
+        e.EndAdjustments[outIdx] = unplayedTotal
+        e.Scores[outIdx] += unplayedTotal
+        e.record(EVENT_END_RACK, outIdx, nil, Move{EVENT_END_RACK, outIdx, unplayed, nil}, unplayedTotal)
+    }
+    e.IsOver = true
+}
+
+func (e *Engine) unfinish() {
+    for i := 0; i < 4; i++ {
+        e.Scores[i] -= e.EndAdjustments[i]
+        e.EndAdjustments[i] = 0
+    }
+    e.IsOver = false
+}
+
+// the clock is kept by the front end, which takes off any penalty for going over time once the game is over
+func (e *Engine) AddTimePenalty(player int32, points int32) {
+    e.Scores[player] -= points
+    e.TimePenalties[player] += points
+    e.record(EVENT_TIME_PENALTY, player, e.Racks[player], Move{EVENT_TIME_PENALTY, player, nil, nil}, -points)
+}
+
+// the rack and the move are copied, since the caller may go on to change them
+func (e *Engine) record(kind, player int32, rack []int16, move Move, score int32) {
+    ev := Event{kind, player, nil, move, "", score, e.Scores[player]}
+    ev.Rack = append(ev.Rack, rack...)
+    ev.Move.Tiles = append([]int16(nil), move.Tiles...)
+    ev.Move.Positions = append([]int(nil), move.Positions...)
+    ev.Notation = e.formatEvent(&ev)
+    e.History = append(e.History, ev)
+}
+
+// IsChallengeable is true from the moment a play is made until the next move
+func (e *Engine) IsChallengeable() bool {
+    return e.last.isChallengeable
+}
@@ -0,0 +1,435 @@
+package engine
+
+import "testing"
+
+// a two player game with the built-in tiles and board, and only the given words in the lexicon
+func makeTestEngine(t *testing.T, words ...string) *Engine {
+    e := &Engine{}
+    e.Init(words, DefaultTiles[:])
+    e.Seed = 1
+    e.Reset()
+    e.IsActive[0] = true
+    e.IsActive[1] = true
+    return e
+}
+
+// lowercase letters are blanks, as in the notation
+func makeTiles(t *testing.T, e *Engine, letters string) []int16 {
+    var tiles []int16
+    for len(letters) > 0 {
+        tile, n := e.matchLetter(letters)
+        if tile == 0 {
+            t.Fatalf("\"%s\" doesn't start with a letter", letters)
+        }
+        tiles = append(tiles, tile)
+        letters = letters[n:]
+    }
+    return tiles
+}
+
+// swaps the rack for the given tiles, taking them out of the bag so that every tile is still accounted for. '?' is a blank
+func setRack(t *testing.T, e *Engine, player int32, letters string) {
+    for _, tile := range e.Racks[player] {
+        e.PutTile(tile)
+    }
+    e.Racks[player] = nil
+
+    tiles, err := e.parseRack(letters)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, tile := range tiles {
+        found := false
+        for i := 0; i < len(e.Bag); i++ {
+            if e.Bag[i] == tile {
+                e.Bag = append(e.Bag[:i], e.Bag[i+1:]...)
+                found = true
+                break
+            }
+        }
+        if !found {
+            t.Fatalf("there's no %s left in the bag", e.formatTile(tile))
+        }
+        e.Racks[player] = append(e.Racks[player], tile)
+    }
+}
+
+func play(t *testing.T, e *Engine, player int32, letters string, positions ...int) Result {
+    return apply(t, e, Move{MOVE_PLAY, player, makeTiles(t, e, letters), positions})
+}
+
+func apply(t *testing.T, e *Engine, move Move) Result {
+    res, err := e.Apply(move)
+    if err != nil {
+        t.Fatalf("%v: %v", move, err)
+    }
+    return res
+}
+
+func historyKinds(e *Engine) []int32 {
+    var kinds []int32
+    for _, ev := range e.History {
+        kinds = append(kinds, ev.Kind)
+    }
+    return kinds
+}
+
+func TestApplyPlay(t *testing.T) {
+    e := makeTestEngine(t, "CAT")
+    e.Deal(0)
+    setRack(t, e, 0, "CATEEEE")
+    bagSize := len(e.Bag)
+
+    res := play(t, e, 0, "CAT", 111, 112, 113)
+    if res.Score != 10 || e.Scores[0] != 10 {
+        t.Errorf("CAT over the start square should score 10, got %d", res.Score)
+    }
+    if len(res.Drawn) != 3 || len(e.Racks[0]) != 7 || len(e.Bag) != bagSize - 3 {
+        t.Errorf("the rack should be refilled from the bag, drew %d", len(res.Drawn))
+    }
+    if e.Turn != 1 || e.ScorelessTurns != 0 || !e.IsChallengeable() {
+        t.Errorf("it should be player 2's turn with the play open to a challenge, turn %d", e.Turn)
+    }
+    if len(res.Words) != 1 || res.Words[0].Text != "CAT" || !res.Words[0].IsValid {
+        t.Errorf("the play should make the word CAT, got %v", res.Words)
+    }
+}
+
+func TestApplyRejectsBadMoves(t *testing.T) {
+    e := makeTestEngine(t, "CAT")
+    e.Deal(0)
+    setRack(t, e, 0, "CAT?EEE")
+    setRack(t, e, 1, "DOGEEEE")
+
+    bad := []Move{
+        {MOVE_PLAY, 1, makeTiles(t, e, "DOG"), []int{111, 112, 113}}, // not their turn
+        {MOVE_PLAY, 2, makeTiles(t, e, "DOG"), []int{111, 112, 113}}, // not playing
+        {MOVE_PLAY, 4, nil, nil},
+        {MOVE_PLAY, 0, makeTiles(t, e, "DOG"), []int{111, 112, 113}}, // not on the rack
+        {MOVE_PLAY, 0, makeTiles(t, e, "CAT"), []int{111, 112}},
+        {MOVE_PLAY, 0, makeTiles(t, e, "CAT"), []int{0, 1, 2}}, // misses the start square
+        {MOVE_PLAY, 0, []int16{e.BlankTile, 1}, []int{112, 113}}, // the blank needs a letter
+        {MOVE_EXCHANGE, 0, nil, nil},
+        {MOVE_EXCHANGE, 0, makeTiles(t, e, "Z"), nil},
+        {MOVE_CHALLENGE, 1, nil, nil}, // nothing to challenge yet
+        {9, 0, nil, nil},
+    }
+    for _, move := range bad {
+        if _, err := e.Apply(move); err == nil {
+            t.Errorf("%v should be refused", move)
+        }
+    }
+    if len(e.History) != 0 || e.Turn != 0 {
+        t.Errorf("refused moves shouldn't change the game, %d events, turn %d", len(e.History), e.Turn)
+    }
+
+    e.ShouldValidate = true
+    if _, err := e.Apply(Move{MOVE_PLAY, 0, makeTiles(t, e, "TAC"), []int{111, 112, 113}}); err == nil {
+        t.Error("TAC should be refused when every word is validated")
+    }
+    play(t, e, 0, "cAT", 111, 112, 113)
+    if e.Scores[0] != 4 {
+        t.Errorf("the blank should score nothing, got %d", e.Scores[0])
+    }
+}
+
+func TestApplyExchangeAndPass(t *testing.T) {
+    e := makeTestEngine(t)
+    e.ScorelessTurnLimit = 3
+    e.Deal(0)
+    setRack(t, e, 0, "QZAEIOU")
+    bagSize := len(e.Bag)
+
+    res := apply(t, e, Move{MOVE_EXCHANGE, 0, makeTiles(t, e, "QZ"), nil})
+    if len(res.Drawn) != 2 || len(e.Racks[0]) != 7 || len(e.Bag) != bagSize {
+        t.Errorf("two tiles should be drawn and two put back, drew %d, bag %d", len(res.Drawn), len(e.Bag))
+    }
+
+    apply(t, e, Move{MOVE_PASS, 1, nil, nil})
+    if e.ScorelessTurns != 2 || e.IsOver {
+        t.Errorf("an exchange and a pass are 2 scoreless turns, got %d", e.ScorelessTurns)
+    }
+    res = apply(t, e, Move{MOVE_PASS, 0, nil, nil})
+    if !res.IsGameOver || !e.IsOver {
+        t.Error("the game should end after 3 scoreless turns")
+    }
+    for i := 0; i < 2; i++ {
+        if e.EndAdjustments[i] != -e.GetRackValue(int32(i)) || e.Scores[i] != e.EndAdjustments[i] {
+            t.Errorf("player %d should lose what's left on their rack, lost %d", i + 1, e.EndAdjustments[i])
+        }
+    }
+    if _, err := e.Apply(Move{MOVE_PASS, e.Turn, nil, nil}); err == nil {
+        t.Error("no moves should be allowed once the game is over")
+    }
+
+    e.Reset()
+    e.Deal(0)
+    setRack(t, e, 0, "ABCDEFG")
+    e.Bag = e.Bag[:6]
+    if _, err := e.Apply(Move{MOVE_EXCHANGE, 0, makeTiles(t, e, "A"), nil}); err == nil {
+        t.Error("exchanges need at least a rack's worth of tiles in the bag")
+    }
+}
+
+func TestGoingOut(t *testing.T) {
+    e := makeTestEngine(t, "CAT")
+    e.Deal(0)
+    setRack(t, e, 0, "CAT")
+    setRack(t, e, 1, "QZ")
+    e.Bag = e.Bag[0:0]
+
+    res := play(t, e, 0, "CAT", 111, 112, 113)
+    if !res.IsGameOver {
+        t.Fatal("using the last tiles with an empty bag should end the game")
+    }
+    if e.EndAdjustments[0] != 20 || e.EndAdjustments[1] != -20 || e.Scores[0] != 30 || e.Scores[1] != -20 {
+        t.Errorf("player 1 should get the 20 points left on player 2's rack, scores %v", e.Scores)
+    }
+}
+
+// a phony that uses the last tiles ends the game, but taking it back carries the game on
+func TestChallengeGameEndingPlay(t *testing.T) {
+    setup := func(words ...string) *Engine {
+        e := makeTestEngine(t, words...)
+        e.ChallengeRule = CHALLENGE_POINTS
+        e.ChallengePoints = 5
+        e.Deal(0)
+        setRack(t, e, 0, "CAT")
+        setRack(t, e, 1, "QZ")
+        e.Bag = e.Bag[0:0]
+        if res := play(t, e, 0, "CAT", 111, 112, 113); !res.IsGameOver || !e.IsChallengeable() {
+            t.Fatal("the play should end the game and still be open to a challenge")
+        }
+        return e
+    }
+
+    e := setup()
+    res := apply(t, e, Move{MOVE_CHALLENGE, 1, nil, nil})
+    if !res.IsUpheld || res.IsGameOver || e.IsOver || e.Turn != 1 {
+        t.Fatalf("the challenge should be upheld and the game carry on with player 2, turn %d, over %v", e.Turn, e.IsOver)
+    }
+    if e.Scores != [4]int32{} || e.EndAdjustments != [4]int32{} || e.Board[112] != 0 || len(e.Racks[0]) != 3 {
+        t.Errorf("the play and the end of the game should be taken back, scores %v, adjustments %v", e.Scores, e.EndAdjustments)
+    }
+    kinds := historyKinds(e)
+    if len(kinds) != 2 || kinds[0] != MOVE_PLAY || kinds[1] != EVENT_WITHDRAWN {
+        t.Errorf("the racks left at the end should come out of the record, got %v", kinds)
+    }
+    apply(t, e, Move{MOVE_PASS, 1, nil, nil})
+
+    // a valid play still ends the game, with the penalty for the challenge on top
+    e = setup("CAT")
+    res = apply(t, e, Move{MOVE_CHALLENGE, 1, nil, nil})
+    if res.IsUpheld || !res.IsGameOver || !e.IsOver || e.Scores[0] != 30 || e.Scores[1] != -25 {
+        t.Errorf("the challenge should fail and the game stay over, scores %v", e.Scores)
+    }
+    if _, err := e.Apply(Move{MOVE_CHALLENGE, 1, nil, nil}); err == nil {
+        t.Error("the play can't be challenged twice")
+    }
+    if _, err := e.Apply(Move{MOVE_PASS, 1, nil, nil}); err == nil {
+        t.Error("no more moves can be made once the game is over")
+    }
+}
+
+func TestChallengeRules(t *testing.T) {
+    setup := func(rule int32) *Engine {
+        e := makeTestEngine(t, "CAT")
+        e.ChallengeRule = rule
+        e.ChallengePoints = 5
+        e.Deal(0)
+        setRack(t, e, 0, "CATEEEE")
+        play(t, e, 0, "CAT", 111, 112, 113)
+        return e
+    }
+
+    e := setup(CHALLENGE_SINGLE)
+    if _, err := e.Apply(Move{MOVE_CHALLENGE, 0, nil, nil}); err == nil {
+        t.Error("players shouldn't be able to challenge their own play")
+    }
+    res := apply(t, e, Move{MOVE_CHALLENGE, 1, nil, nil})
+    if res.IsUpheld || e.Turn != 1 || e.Scores[1] != 0 || len(e.History) != 1 {
+        t.Errorf("a failed challenge under the single rule costs nothing, turn %d, score %d", e.Turn, e.Scores[1])
+    }
+    if _, err := e.Apply(Move{MOVE_CHALLENGE, 1, nil, nil}); err == nil {
+        t.Error("a play can only be challenged once")
+    }
+
+    // it's already the challenger's turn, so that's the one they lose
+    e = setup(CHALLENGE_DOUBLE)
+    res = apply(t, e, Move{MOVE_CHALLENGE, 1, nil, nil})
+    if res.Skipped != 1 || e.Turn != 0 || e.SkipNextTurn[1] {
+        t.Errorf("player 2 should lose their turn, skipped %d, turn %d", res.Skipped, e.Turn)
+    }
+    kinds := historyKinds(e)
+    if len(kinds) != 2 || kinds[1] != MOVE_PASS {
+        t.Errorf("the lost turn should be recorded as a pass, got %v", kinds)
+    }
+
+    // the play made player 2 serve a lost turn, so player 3 challenges on their own turn and play goes on to player 1
+    e = makeTestEngine(t, "CAT")
+    e.IsActive[2] = true
+    e.ChallengeRule = CHALLENGE_DOUBLE
+    e.Deal(0)
+    setRack(t, e, 0, "CATEEEE")
+    e.SkipNextTurn[1] = true
+    res = play(t, e, 0, "CAT", 111, 112, 113)
+    if res.Skipped != 1 || e.Turn != 2 {
+        t.Fatalf("player 2 should have lost their turn, turn %d", e.Turn)
+    }
+    res = apply(t, e, Move{MOVE_CHALLENGE, 2, nil, nil})
+    if res.Skipped != 2 || e.Turn != 0 || e.SkipNextTurn != [4]bool{} {
+        t.Errorf("player 3 should lose their turn to player 1, skipped %d, turn %d, skips %v", res.Skipped, e.Turn, e.SkipNextTurn)
+    }
+    kinds = historyKinds(e)
+    if len(kinds) != 3 || kinds[1] != MOVE_PASS || kinds[2] != MOVE_PASS || e.History[2].Player != 2 {
+        t.Errorf("both lost turns should be recorded as passes, got %v", kinds)
+    }
+
+    // a challenger whose turn hasn't come yet loses their next one
+    e = makeTestEngine(t, "CAT")
+    e.IsActive[2] = true
+    e.ChallengeRule = CHALLENGE_DOUBLE
+    e.Deal(0)
+    setRack(t, e, 0, "CATEEEE")
+    play(t, e, 0, "CAT", 111, 112, 113)
+    res = apply(t, e, Move{MOVE_CHALLENGE, 2, nil, nil})
+    if res.Skipped != -1 || e.Turn != 1 || !e.SkipNextTurn[2] {
+        t.Errorf("player 3 should lose their next turn, turn %d, skips %v", e.Turn, e.SkipNextTurn)
+    }
+
+    e = setup(CHALLENGE_POINTS)
+    res = apply(t, e, Move{MOVE_CHALLENGE, 1, nil, nil})
+    if res.Penalty != 5 || e.Scores[1] != -5 || e.ChallengePenalties[1] != 5 || e.Turn != 1 {
+        t.Errorf("player 2 should lose 5 points and keep their turn, score %d, turn %d", e.Scores[1], e.Turn)
+    }
+    kinds = historyKinds(e)
+    if len(kinds) != 2 || kinds[1] != EVENT_CHALLENGE_PENALTY {
+        t.Errorf("the penalty should be recorded, got %v", kinds)
+    }
+
+    // the next move closes the window for a challenge
+    e = setup(CHALLENGE_SINGLE)
+    apply(t, e, Move{MOVE_PASS, 1, nil, nil})
+    if e.IsChallengeable() {
+        t.Error("the play shouldn't be challengeable after the next move")
+    }
+}
+
+func TestUpheldChallenge(t *testing.T) {
+    e := makeTestEngine(t)
+    e.ChallengeRule = CHALLENGE_POINTS
+    e.Deal(0)
+    setRack(t, e, 0, "C?TEEEE")
+    rack := append([]int16(nil), e.Racks[0]...)
+    bagSize := len(e.Bag)
+
+    play(t, e, 0, "CaT", 111, 112, 113)
+    res := apply(t, e, Move{MOVE_CHALLENGE, 1, nil, nil})
+    if !res.IsUpheld || len(res.Words) != 1 || res.Words[0].Text != "CAT" {
+        t.Fatalf("the challenge against CAT should be upheld, got %v", res.Words)
+    }
+    if len(res.Returned) != 3 || res.Returned[1] != e.BlankTile {
+        t.Errorf("the tiles should go back on the rack as they were, with the blank unassigned, got %v", res.Returned)
+    }
+    if e.Scores[0] != 0 || e.Scores[1] != 0 || e.Board[112] != 0 || len(e.Bag) != bagSize || e.Turn != 1 {
+        t.Errorf("the play should be taken back with no penalty, scores %v, turn %d", e.Scores, e.Turn)
+    }
+    if !e.hasTiles(0, rack) || len(e.Racks[0]) != len(rack) {
+        t.Errorf("the rack should be what it was before the play, got %v", e.Racks[0])
+    }
+    if e.History[1].Kind != EVENT_WITHDRAWN || e.History[1].Score != -8 {
+        t.Errorf("the withdrawal should be recorded, got %v", e.History[1])
+    }
+}
+
+func TestWithdrawnPlayKeepsSkippedTurn(t *testing.T) {
+    e := makeTestEngine(t, "QI")
+    e.ChallengeRule = CHALLENGE_DOUBLE
+    setRack(t, e, 0, "QXAEIOU")
+    setRack(t, e, 1, "ABCDEFG")
+    e.SkipNextTurn[1] = true
+
+    res := play(t, e, 0, "QX", 112, 113)
+    if res.Skipped != 1 || e.Turn != 0 {
+        t.Fatalf("player 2 should have lost their turn, skipped %d, turn %d", res.Skipped, e.Turn)
+    }
+
+    res = apply(t, e, Move{MOVE_CHALLENGE, 1, nil, nil})
+    if !res.IsUpheld {
+        t.Fatal("the challenge against QX should be upheld")
+    }
+    // the skipped turn is still served, but after the play is withdrawn
+    if res.Skipped != 1 || e.Turn != 0 || e.SkipNextTurn[1] {
+        t.Errorf("player 2 should lose their turn once, skipped %d, turn %d, still skipping %v", res.Skipped, e.Turn, e.SkipNextTurn[1])
+    }
+    kinds := historyKinds(e)
+    if len(kinds) != 3 || kinds[0] != MOVE_PLAY || kinds[1] != EVENT_WITHDRAWN || kinds[2] != MOVE_PASS {
+        t.Errorf("expected a play, its withdrawal and then the lost turn, got %v", kinds)
+    }
+    if e.ScorelessTurns != 2 {
+        t.Errorf("the withdrawal and the lost turn should both be scoreless, got %d", e.ScorelessTurns)
+    }
+    if e.Scores[0] != 0 || e.Board[112] != 0 || len(e.Racks[0]) != 7 {
+        t.Errorf("the play should be taken back, score %d, board %d, rack %d tiles", e.Scores[0], e.Board[112], len(e.Racks[0]))
+    }
+}
+
+func TestSeedGivesTheSameDraws(t *testing.T) {
+    draw := func(seed uint64) []int16 {
+        e := makeTestEngine(t)
+        e.Seed = seed
+        e.Reset()
+        var tiles []int16
+        for i := 0; i < 20; i++ {
+            tiles = append(tiles, e.TakeTile())
+        }
+        return tiles
+    }
+
+    a := draw(7)
+    b := draw(7)
+    c := draw(8)
+    same := true
+    for i := range a {
+        if a[i] != b[i] {
+            t.Fatalf("the same seed should draw the same tiles, %v and %v", a, b)
+        }
+        same = same && a[i] == c[i]
+    }
+    if same {
+        t.Error("different seeds should draw different tiles")
+    }
+}
+
+func TestLegalMoves(t *testing.T) {
+    e := makeTestEngine(t, "CAT", "AT")
+    e.Deal(0)
+    setRack(t, e, 0, "CATVVXZ")
+
+    moves, err := e.LegalMoves(0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if moves[len(moves) - 1].Kind != MOVE_PASS {
+        t.Error("the last move should be a pass")
+    }
+    for _, move := range moves[:len(moves) - 1] {
+        if reason := e.CheckPlacement(move.Positions); reason != PLACE_OK {
+            t.Errorf("%v isn't placed properly: %s", move, PlacementErrorStrings[reason])
+        }
+        for _, w := range e.FindWords(move.Tiles, move.Positions) {
+            if !w.IsValid {
+                t.Errorf("%v makes %s, which isn't a word", move, w.Text)
+            }
+        }
+    }
+    if len(moves) < 2 {
+        t.Error("CAT and AT can both be played")
+    }
+
+    for _, player := range []int32{-1, 4, 7} {
+        if _, err := e.LegalMoves(player); err == nil {
+            t.Errorf("there is no player %d", player + 1)
+        }
+    }
+}
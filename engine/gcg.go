@@ -0,0 +1,326 @@
+package engine
+
+import "errors"
+import "strconv"
+import "strings"
+
+// games are recorded in the GCG format that other programs use for looking back over games.
+// each player is given the nickname p1 to p4, and each event in the history is one line:
+//   >p1: RACK 8G QUIZ +34 34       a play, with any letters it goes through that were already on the board as '.'
+//   >p1: RACK -ABC +0 34           an exchange, and "-" on its own for a pass
+//   >p1: RACK -- -34 0             a play taken back after a challenge
+//   >p2: RACK (challenge) -5 29    points lost for challenging a valid play
+//   >p2: RACK (AEI) -3 26          the tiles left at the end, and the player who went out gets them without a rack
+//   >p2: RACK (time) -10 16        points lost for going over time
+// the version of the record is in a #scrambles-version pragma. records from other programs don't have one,
+// and are read as version 1. the size of the board and the letters in the tile set are written in pragmas too,
+// so that a record isn't read into a game that it doesn't fit
+
+const GCG_VERSION = 1
+const GCG_VERSION_PRAGMA = "#scrambles-version "
+const GCG_BOARD_SIZE_PRAGMA = "#scrambles-board-size "
+const GCG_TILES_PRAGMA = "#scrambles-tiles "
+
+var gcgMigrations = [GCG_VERSION - 1]Migration{}
+
+func (e *Engine) formatRack(tiles []int16) string {
+    text := ""
+    for _, tile := range tiles {
+        text += e.formatTile(tile)
+    }
+    return text
+}
+
+func (e *Engine) parseRack(text string) ([]int16, error) {
+    var tiles []int16
+    for len(text) > 0 {
+        if text[0] == '?' {
+            tiles = append(tiles, e.BlankTile)
+            text = text[1:]
+            continue
+        }
+        tile, n := e.matchLetter(text)
+        if tile == 0 {
+            return nil, errors.New("\"" + text + "\" doesn't start with a letter in the tile set")
+        }
+        tiles = append(tiles, tile & LETTER_MASK)
+        text = text[n:]
+    }
+    return tiles, nil
+}
+
+func (e *Engine) formatEvent(ev *Event) string {
+    if ev.Kind == EVENT_WITHDRAWN {
+        return "--"
+    } else if ev.Kind == EVENT_CHALLENGE_PENALTY {
+        return "(challenge)"
+    } else if ev.Kind == EVENT_TIME_PENALTY {
+        return "(time)"
+    } else if ev.Kind == EVENT_END_RACK {
+        return "(" + e.formatRack(ev.Move.Tiles) + ")"
+    }
+    text, _ := e.FormatMove(ev.Move, false)
+    return text
+}
+
+func formatScore(score int32) string {
+    if score >= 0 {
+        return "+" + strconv.Itoa(int(score))
+    }
+    return strconv.Itoa(int(score))
+}
+
+func (e *Engine) WriteGCG() string {
+    var builder strings.Builder
+    builder.WriteString("#character-encoding UTF-8\n")
+    builder.WriteString(GCG_VERSION_PRAGMA + strconv.Itoa(GCG_VERSION) + "\n")
+    builder.WriteString(GCG_BOARD_SIZE_PRAGMA + strconv.Itoa(e.Layout.Size) + "\n")
+    builder.WriteString(GCG_TILES_PRAGMA + e.formatLetters() + "\n")
+    for i := 0; i < 4; i++ {
+        if e.IsActive[i] {
+            n := strconv.Itoa(i + 1)
+            builder.WriteString("#player" + n + " p" + n + " Player " + n + "\n")
+        }
+    }
+
+    // plays are written again the way other programs read them, on the board as it was at the time
+    board := make([]int16, len(e.Board))
+    for i, ev := range e.History {
+        notation := ev.Notation
+        if ev.Kind == MOVE_PLAY {
+            notation, _ = e.formatMove(ev.Move, board, true)
+        }
+        builder.WriteString(">p" + strconv.Itoa(int(ev.Player) + 1) + ": " + e.formatRack(ev.Rack) + " " + notation)
+        builder.WriteString(" " + formatScore(ev.Score) + " " + strconv.Itoa(int(ev.Total)) + "\n")
+        e.placeEvent(board, i)
+    }
+    return builder.String()
+}
+
+// some programs only write down how many tiles were exchanged, as in "-3"
+func isExchangeCount(action string) bool {
+    if len(action) < 2 || action[0] != '-' {
+        return false
+    }
+    _, err := strconv.Atoi(action[1:])
+    return err == nil
+}
+
+// the letters in the tile set, in order and without the blank
+func (e *Engine) formatLetters() string {
+    var letters []string
+    for i := 0; i < e.GetLetterCount(); i++ {
+        letters = append(letters, e.TileSet[i].Letter)
+    }
+    return strings.Join(letters, " ")
+}
+
+// returns the rest of the first line that starts with the prefix
+func findPragma(lines []string, prefix string) (string, bool) {
+    for _, l := range lines {
+        l = strings.TrimSpace(l)
+        if strings.HasPrefix(l, prefix) {
+            return strings.TrimSpace(l[len(prefix):]), true
+        }
+    }
+    return "", false
+}
+
+// a record without the pragmas is still checked move by move, since every square and letter in it has to be read
+func (e *Engine) checkGCGFits(lines []string) error {
+    size, exists := findPragma(lines, GCG_BOARD_SIZE_PRAGMA)
+    if exists && size != strconv.Itoa(e.Layout.Size) {
+        return errors.New("The game record is for a board that's " + size + " squares across, but this one is " +
+            strconv.Itoa(e.Layout.Size))
+    }
+    letters, exists := findPragma(lines, GCG_TILES_PRAGMA)
+    if exists && strings.Join(strings.Fields(letters), " ") != e.formatLetters() {
+        return errors.New("The game record is for a different tile set, with the letters " + letters)
+    }
+    return nil
+}
+
+func gcgLineError(n int, msg string) error {
+    return errors.New("line " + strconv.Itoa(n + 1) + " of the game record " + msg)
+}
+
+// rebuilds the game from a GCG record, going by the racks and scores written in it rather than drawing from the bag.
+// afterwards the game can be looked through with ReplayTo, but not carried on with
+func (e *Engine) ReadGCG(lines []string) error {
+    version, err := FindVersion(lines, GCG_VERSION_PRAGMA)
+    if err != nil {
+        return err
+    }
+    lines, err = Migrate("game record", lines, max(version, 1), gcgMigrations[:])
+    if err != nil {
+        return err
+    }
+    err = e.checkGCGFits(lines)
+    if err != nil {
+        return err
+    }
+
+    nicknames := make(map[string]int32)
+    e.IsActive = [4]bool{}
+    e.History = nil
+    e.clearReplay()
+
+    for n, l := range lines {
+        l = strings.TrimSpace(l)
+        if strings.HasPrefix(l, "#player") {
+            fields := strings.Fields(l)
+            idx, err := strconv.Atoi(fields[0][len("#player"):])
+            if err != nil || idx < 1 || idx > 4 || len(fields) < 2 {
+                return gcgLineError(n, "should be #player1 to #player4, followed by a nickname")
+            }
+            nicknames[fields[1]] = int32(idx - 1)
+            e.IsActive[idx - 1] = true
+            continue
+        }
+        // other pragmas and notes aren't needed to rebuild the game
+        if !strings.HasPrefix(l, ">") {
+            continue
+        }
+
+        colon := strings.IndexByte(l, ':')
+        if colon < 0 {
+            return gcgLineError(n, "should start with a nickname and a colon")
+        }
+        player, exists := nicknames[l[1:colon]]
+        if !exists {
+            return gcgLineError(n, "is for \"" + l[1:colon] + "\", who isn't one of the players")
+        }
+
+        fields := strings.Fields(l[colon+1:])
+        if len(fields) < 3 || len(fields) > 5 {
+            return gcgLineError(n, "should be a rack, a move, the points scored and the total score")
+        }
+        score, err := strconv.Atoi(fields[len(fields)-2])
+        if err != nil {
+            return gcgLineError(n, "should have the points scored before the total score")
+        }
+        total, err := strconv.Atoi(fields[len(fields)-1])
+        if err != nil {
+            return gcgLineError(n, "should end with the total score")
+        }
+        fields = fields[:len(fields)-2]
+
+        // the player who goes out has no rack left to write down
+        ev := Event{MOVE_PLAY, player, nil, Move{MOVE_PLAY, player, nil, nil}, "", int32(score), int32(total)}
+        action := fields[len(fields)-1]
+        if len(fields) == 3 {
+            action = fields[1] + " " + fields[2]
+        }
+        if len(fields) > 1 {
+            ev.Rack, err = e.parseRack(fields[0])
+            if err != nil {
+                return gcgLineError(n, err.Error())
+            }
+        }
+
+        if action == "--" {
+            ev.Kind = EVENT_WITHDRAWN
+        } else if action == "(challenge)" {
+            ev.Kind = EVENT_CHALLENGE_PENALTY
+        } else if action == "(time)" {
+            ev.Kind = EVENT_TIME_PENALTY
+        } else if strings.HasPrefix(action, "(") && strings.HasSuffix(action, ")") {
+            ev.Kind = EVENT_END_RACK
+            ev.Move.Tiles, err = e.parseRack(action[1:len(action)-1])
+        } else if isExchangeCount(action) {
+            ev.Kind = MOVE_EXCHANGE
+        } else {
+            ev.Move, err = e.ParseMove(player, action)
+            ev.Kind = ev.Move.Kind
+        }
+        if err != nil {
+            return gcgLineError(n, err.Error())
+        }
+        ev.Move.Kind = ev.Kind
+
+        ev.Notation = e.formatEvent(&ev)
+        e.History = append(e.History, ev)
+        e.applyEvent(len(e.History) - 1)
+    }
+
+    e.last = lastPlay{}
+    e.IsOver = true
+    return nil
+}
+
+// rebuilds the board, the scores and the racks as they were after the first n events in the history.
+// each player's rack is the one they had before their last event, less any tiles they played or exchanged.
+// nothing is changed if the history doesn't fit the board and the tile set, say if the layout changed after it was read
+func (e *Engine) ReplayTo(n int) error {
+    for i, ev := range e.History {
+        for _, pos := range ev.Move.Positions {
+            if pos < 0 || pos >= len(e.Board) {
+                return errors.New("Event " + strconv.Itoa(i + 1) + " of the game record is off the board")
+            }
+        }
+        for _, tile := range ev.Move.Tiles {
+            if (tile & LETTER_MASK) < 1 || (tile & LETTER_MASK) > e.BlankTile {
+                return errors.New("Event " + strconv.Itoa(i + 1) + " of the game record has a tile that isn't in the tile set")
+            }
+        }
+    }
+
+    e.clearReplay()
+    for i := 0; i < n && i < len(e.History); i++ {
+        e.applyEvent(i)
+    }
+    return nil
+}
+
+func (e *Engine) clearReplay() {
+    for i := 0; i < len(e.Board); i++ {
+        e.Board[i] = 0
+    }
+    for i := 0; i < 4; i++ {
+        e.Racks[i] = e.Racks[i][0:0]
+        e.Scores[i] = 0
+        e.EndAdjustments[i] = 0
+        e.ChallengePenalties[i] = 0
+        e.TimePenalties[i] = 0
+    }
+}
+
+func (e *Engine) applyEvent(idx int) {
+    ev := &e.History[idx]
+    p := ev.Player
+    e.Racks[p] = append(e.Racks[p][0:0], ev.Rack...)
+
+    e.placeEvent(e.Board, idx)
+
+    if ev.Kind == MOVE_PLAY || ev.Kind == MOVE_EXCHANGE {
+        for _, tile := range ev.Move.Tiles {
+            e.removeFromRack(p, tile)
+        }
+    } else if ev.Kind == EVENT_CHALLENGE_PENALTY {
+        e.ChallengePenalties[p] -= ev.Score
+    } else if ev.Kind == EVENT_END_RACK {
+        e.EndAdjustments[p] += ev.Score
+    } else if ev.Kind == EVENT_TIME_PENALTY {
+        e.TimePenalties[p] -= ev.Score
+    }
+    e.Scores[p] = ev.Total
+}
+
+// puts a play's tiles on the board, or takes them off again if the event withdraws it
+func (e *Engine) placeEvent(board []int16, idx int) {
+    ev := &e.History[idx]
+    if ev.Kind == MOVE_PLAY {
+        for i, pos := range ev.Move.Positions {
+            board[pos] = ev.Move.Tiles[i]
+        }
+    } else if ev.Kind == EVENT_WITHDRAWN {
+        for i := idx - 1; i >= 0; i-- {
+            if e.History[i].Kind == MOVE_PLAY && e.History[i].Player == ev.Player {
+                for _, pos := range e.History[i].Move.Positions {
+                    board[pos] = 0
+                }
+                break
+            }
+        }
+    }
+}
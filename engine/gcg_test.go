@@ -0,0 +1,155 @@
+package engine
+
+import "reflect"
+import "strings"
+import "testing"
+
+// a short game with every kind of event in it: plays, an exchange, a blank, a withdrawn phony, a challenge penalty,
+// passes up to the scoreless turn limit, the racks left at the end and a time penalty
+func playScriptedGame(t *testing.T) *Engine {
+    e := makeTestEngine(t, "CAT", "CATS", "TAE")
+    e.ChallengeRule = CHALLENGE_POINTS
+    e.ChallengePoints = 5
+    e.ScorelessTurnLimit = 4
+    e.Deal(0)
+    setRack(t, e, 0, "CATEEEE")
+    setRack(t, e, 1, "SSOOUUN")
+
+    play(t, e, 0, "CAT", 111, 112, 113)
+    apply(t, e, Move{MOVE_EXCHANGE, 1, makeTiles(t, e, "UU"), nil})
+    setRack(t, e, 0, "T?IIIII")
+    play(t, e, 0, "Te", 97, 127)
+    play(t, e, 1, "S", 110)
+    if res := apply(t, e, Move{MOVE_CHALLENGE, 0, nil, nil}); !res.IsUpheld {
+        t.Fatal("the challenge against SCAT should be upheld")
+    }
+    apply(t, e, Move{MOVE_PASS, 0, nil, nil})
+    play(t, e, 1, "S", 114)
+    if res := apply(t, e, Move{MOVE_CHALLENGE, 0, nil, nil}); res.IsUpheld || res.Penalty != 5 {
+        t.Fatal("the challenge against CATS should cost 5 points")
+    }
+    for i := 0; i < 10 && !e.IsOver; i++ {
+        apply(t, e, Move{MOVE_PASS, e.Turn, nil, nil})
+    }
+    if !e.IsOver {
+        t.Fatal("the game should end after 4 scoreless turns")
+    }
+    e.AddTimePenalty(1, 10)
+    return e
+}
+
+func TestGCGRoundTrip(t *testing.T) {
+    e := playScriptedGame(t)
+    text := e.WriteGCG()
+    for _, notation := range []string{">p1: CATEEEE 8G CAT +10 10", " -UU ", " H7 T.e ", " 8F S... ", " 8G ...S +", " -- ", " (challenge) -5 ", " (time) -10 "} {
+        if !strings.Contains(text, notation) {
+            t.Errorf("the record should have \"%s\" in it:\n%s", notation, text)
+        }
+    }
+
+    r := makeTestEngine(t)
+    err := r.ReadGCG(strings.Split(text, "\n"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !reflect.DeepEqual(r.Board, e.Board) {
+        t.Error("the board read back isn't the one that was played")
+    }
+    if r.Scores != e.Scores || r.EndAdjustments != e.EndAdjustments || r.ChallengePenalties != e.ChallengePenalties ||
+        r.TimePenalties != e.TimePenalties {
+        t.Errorf("the scores read back should be %v, got %v", e.Scores, r.Scores)
+    }
+    if r.IsActive != e.IsActive || len(r.History) != len(e.History) || !r.IsOver {
+        t.Errorf("the players and the history should be read back, got %v and %d events", r.IsActive, len(r.History))
+    }
+    if again := r.WriteGCG(); again != text {
+        t.Errorf("writing the record again should give the same text, got:\n%s\ninstead of:\n%s", again, text)
+    }
+
+    // the exchange is the second event, so the board only has CAT on it
+    if err := r.ReplayTo(2); err != nil {
+        t.Fatal(err)
+    }
+    if r.Board[112] == 0 || r.Board[97] != 0 || r.Scores[0] != 10 || r.Scores[1] != 0 {
+        t.Errorf("after 2 events only CAT should be down, scores %v", r.Scores)
+    }
+    r.ReplayTo(0)
+    for _, tile := range r.Board {
+        if tile != 0 {
+            t.Fatal("the board should be empty before the first event")
+        }
+    }
+    r.ReplayTo(len(r.History))
+    if !reflect.DeepEqual(r.Board, e.Board) || r.Scores != e.Scores {
+        t.Error("replaying every event should give the end of the game")
+    }
+}
+
+func TestReadGCGErrors(t *testing.T) {
+    players := "#player1 p1 Player 1\n#player2 p2 Player 2\n"
+    bad := []string{
+        players + ">p3: CATEEEE 8G CAT +10 10",
+        players + ">p1 CATEEEE 8G CAT +10 10",
+        players + ">p1: CATEEEE 8G CAT ten 10",
+        players + ">p1: CATEEEE Z99 CAT +10 10",
+        players + ">p1: CAT3 8G CAT +10 10",
+        players + ">p2: AB (A3B) -4 -4",
+        "#player5 p5 Player 5\n",
+        GCG_VERSION_PRAGMA + "99\n" + players,
+    }
+    for _, text := range bad {
+        e := makeTestEngine(t)
+        if err := e.ReadGCG(strings.Split(text, "\n")); err == nil {
+            t.Errorf("this record shouldn't be read:\n%s", text)
+        }
+    }
+}
+
+func TestGCGMustFitTheGame(t *testing.T) {
+    text := playScriptedGame(t).WriteGCG()
+    if !strings.Contains(text, GCG_BOARD_SIZE_PRAGMA + "15\n") || !strings.Contains(text, GCG_TILES_PRAGMA + "A B C ") {
+        t.Errorf("the record should say which board and tiles it's for:\n%s", text)
+    }
+
+    small := makeTestEngine(t)
+    small.SetLayout(Layout{7, make([]int32, 49), 24})
+    err := small.ReadGCG(strings.Split(text, "\n"))
+    if err == nil || !strings.Contains(err.Error(), "15 squares across") {
+        t.Errorf("a record for a bigger board shouldn't be read, got %v", err)
+    }
+
+    tiles := append([]Tile(nil), DefaultTiles[:]...)
+    tiles[0].Letter = "Ä"
+    other := &Engine{}
+    other.Init(nil, tiles)
+    err = other.ReadGCG(strings.Split(text, "\n"))
+    if err == nil || !strings.Contains(err.Error(), "tile set") {
+        t.Errorf("a record for a different tile set shouldn't be read, got %v", err)
+    }
+
+    // records from other programs have no pragmas, but their moves still have to fit
+    var foreign []string
+    for _, l := range strings.Split(text, "\n") {
+        if !strings.HasPrefix(l, GCG_BOARD_SIZE_PRAGMA) && !strings.HasPrefix(l, GCG_TILES_PRAGMA) {
+            foreign = append(foreign, l)
+        }
+    }
+    if err := makeTestEngine(t).ReadGCG(foreign); err != nil {
+        t.Errorf("a record without the pragmas should still be read, got %v", err)
+    }
+    small = makeTestEngine(t)
+    small.SetLayout(Layout{7, make([]int32, 49), 24})
+    if err := small.ReadGCG(foreign); err == nil {
+        t.Error("a record with plays off the board shouldn't be read")
+    }
+
+    // the layout can change after the record is read
+    r := makeTestEngine(t)
+    if err := r.ReadGCG(strings.Split(text, "\n")); err != nil {
+        t.Fatal(err)
+    }
+    r.SetLayout(Layout{7, make([]int32, 49), 24})
+    if err := r.ReplayTo(len(r.History)); err == nil {
+        t.Error("replaying onto a smaller board should fail")
+    }
+}
@@ -0,0 +1,124 @@
+package engine
+
+import "sort"
+import "strings"
+
+// words are stored as strings of tile indices (one byte per tile), so that letters made of more than one character
+// (eg. CH or LL) can be looked up the same way as single letters
+type Lexicon struct {
+    words map[string]int32
+    sorted []string
+    letters []string
+    builder strings.Builder
+}
+
+func MakeLexicon(wordsList []string, tileSet []Tile) Lexicon {
+    lex := Lexicon{}
+    lex.letters = make([]string, len(tileSet) - 1)
+    for i := 0; i < len(lex.letters); i++ {
+        lex.letters[i] = tileSet[i].Letter
+    }
+
+    lex.words = make(map[string]int32, len(wordsList))
+    lex.sorted = make([]string, 0, len(wordsList))
+    for i := 0; i < len(wordsList); i++ {
+        key, ok := lex.tokenize(wordsList[i])
+        if !ok || len(key) == 0 {
+            continue
+        }
+        if _, exists := lex.words[key]; !exists {
+            lex.sorted = append(lex.sorted, key)
+        }
+        lex.words[key] = int32(i)
+    }
+
+    // the sorted keys let move generation give up on a line of tiles as soon as no word can start with it
+    sort.Strings(lex.sorted)
+    return lex
+}
+
+// word lists may be in any case and may have been saved with \r\n line endings
+func normalizeWord(word string) string {
+    return strings.ToUpper(strings.TrimSpace(word))
+}
+
+// splits a word into tiles, always taking the longest letter that matches. words that use letters outside the alphabet are rejected
+func (lex *Lexicon) tokenize(word string) (string, bool) {
+    word = normalizeWord(word)
+    lex.builder.Reset()
+    for len(word) > 0 {
+        best := -1
+        for i, letter := range lex.letters {
+            if strings.HasPrefix(word, letter) && (best < 0 || len(letter) > len(lex.letters[best])) {
+                best = i
+            }
+        }
+        if best < 0 {
+            return "", false
+        }
+        lex.builder.WriteByte(byte(best + 1))
+        word = word[len(lex.letters[best]):]
+    }
+    return lex.builder.String(), true
+}
+
+// Tokenize turns a word into the tiles that spell it, without the blank flag. ok is false if the alphabet can't spell it
+func (lex *Lexicon) Tokenize(word string) (tiles []int16, ok bool) {
+    key, ok := lex.tokenize(word)
+    if !ok {
+        return nil, false
+    }
+    tiles = make([]int16, len(key))
+    for i := 0; i < len(key); i++ {
+        tiles[i] = int16(key[i])
+    }
+    return tiles, true
+}
+
+// returns "" for a blank that hasn't been given a letter yet
+func (lex *Lexicon) GetLetter(tile int16) string {
+    idx := int(tile & LETTER_MASK)
+    if idx <= 0 || idx > len(lex.letters) {
+        return ""
+    }
+    return lex.letters[idx - 1]
+}
+
+func (lex *Lexicon) Size() int {
+    return len(lex.words)
+}
+
+func (lex *Lexicon) Contains(word string) bool {
+    key, ok := lex.tokenize(word)
+    if !ok {
+        return false
+    }
+    _, exists := lex.words[key]
+    return exists
+}
+
+func (lex *Lexicon) tilesToKey(tiles []int16) (string, bool) {
+    lex.builder.Reset()
+    for _, tile := range tiles {
+        idx := int(tile & LETTER_MASK)
+        if idx <= 0 || idx > len(lex.letters) {
+            return "", false
+        }
+        lex.builder.WriteByte(byte(idx))
+    }
+    return lex.builder.String(), true
+}
+
+func (lex *Lexicon) ContainsTiles(tiles []int16) bool {
+    key, ok := lex.tilesToKey(tiles)
+    if !ok {
+        return false
+    }
+    _, exists := lex.words[key]
+    return exists
+}
+
+func (lex *Lexicon) hasPrefix(key string) bool {
+    i := sort.SearchStrings(lex.sorted, key)
+    return i < len(lex.sorted) && strings.HasPrefix(lex.sorted[i], key)
+}
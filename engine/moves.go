@@ -0,0 +1,179 @@
+package engine
+
+import "errors"
+import "sort"
+import "strconv"
+import "strings"
+
+// the state of the search for plays along one line of the board
+type moveSearch struct {
+    player int32
+    inc int
+    counts [MAX_LETTERS + 2]int32
+    key []byte
+    tiles []int16
+    positions []int
+    seen map[string]bool
+    moves []Move
+}
+
+// LegalMoves lists every play the player could make with their rack, followed by a pass.
+// exchanges aren't listed, since any selection of tiles can be swapped while the bag has enough of them
+func (e *Engine) LegalMoves(player int32) ([]Move, error) {
+    if player < 0 || player >= 4 {
+        return nil, errors.New("There is no player " + strconv.Itoa(int(player) + 1))
+    }
+
+    s := moveSearch{}
+    s.player = player
+    s.seen = make(map[string]bool)
+    for _, tile := range e.Racks[player] {
+        s.counts[tile]++
+    }
+
+    size := e.Layout.Size
+    if !e.IsOver && e.IsActive[player] {
+        for _, inc := range [2]int{1, size} {
+            s.inc = inc
+            for start := 0; start < size * size; start++ {
+                // a word can only start where the square before it is empty
+                if e.isInLine(start, -inc) && e.Board[start - inc] != 0 {
+                    continue
+                }
+                e.extendMove(&s, start)
+            }
+        }
+    }
+
+    return append(s.moves, Move{MOVE_PASS, player, nil, nil}), nil
+}
+
+// whether stepping by inc from pos stays on the same row or column of the board
+func (e *Engine) isInLine(pos, inc int) bool {
+    size := e.Layout.Size
+    next := pos + inc
+    if next < 0 || next >= size * size {
+        return false
+    }
+    if inc == 1 || inc == -1 {
+        return next / size == pos / size
+    }
+    return true
+}
+
+func (e *Engine) extendMove(s *moveSearch, pos int) {
+    if e.Board[pos] != 0 {
+        s.key = append(s.key, byte(e.Board[pos] & LETTER_MASK))
+        if e.Lexicon.hasPrefix(string(s.key)) {
+            e.continueMove(s, pos)
+        }
+        s.key = s.key[:len(s.key)-1]
+        return
+    }
+
+    for tile := int16(1); tile <= e.BlankTile; tile++ {
+        if s.counts[tile] == 0 {
+            continue
+        }
+        s.counts[tile]--
+        if tile == e.BlankTile {
+            for letter := int16(1); letter < e.BlankTile; letter++ {
+                e.placeMoveTile(s, pos, letter | BLANK_FLAG)
+            }
+        } else {
+            e.placeMoveTile(s, pos, tile)
+        }
+        s.counts[tile]++
+    }
+}
+
+func (e *Engine) placeMoveTile(s *moveSearch, pos int, tile int16) {
+    s.key = append(s.key, byte(tile & LETTER_MASK))
+    if e.Lexicon.hasPrefix(string(s.key)) && e.isCrossWordValid(s, pos, tile) {
+        s.tiles = append(s.tiles, tile)
+        s.positions = append(s.positions, pos)
+        e.continueMove(s, pos)
+        s.tiles = s.tiles[:len(s.tiles)-1]
+        s.positions = s.positions[:len(s.positions)-1]
+    }
+    s.key = s.key[:len(s.key)-1]
+}
+
+func (e *Engine) continueMove(s *moveSearch, pos int) {
+    if len(s.positions) > 0 {
+        e.recordMove(s, pos)
+    }
+    if e.isInLine(pos, s.inc) {
+        e.extendMove(s, pos + s.inc)
+    }
+}
+
+// checks the word that a new tile makes across the line being searched, if it makes one
+func (e *Engine) isCrossWordValid(s *moveSearch, pos int, tile int16) bool {
+    cross := e.Layout.Size
+    if s.inc != 1 {
+        cross = 1
+    }
+
+    start := pos
+    for e.isInLine(start, -cross) && e.Board[start - cross] != 0 {
+        start -= cross
+    }
+    end := pos
+    for e.isInLine(end, cross) && e.Board[end + cross] != 0 {
+        end += cross
+    }
+    if start == end {
+        return true
+    }
+
+    word := make([]int16, 0, 16)
+    for p := start; p <= end; p += cross {
+        if p == pos {
+            word = append(word, tile)
+        } else {
+            word = append(word, e.Board[p])
+        }
+    }
+    return e.Lexicon.ContainsTiles(word)
+}
+
+// the tiles so far make a move if the word along the line ends here and every word they make is in the lexicon
+func (e *Engine) recordMove(s *moveSearch, pos int) {
+    if e.isInLine(pos, s.inc) && e.Board[pos + s.inc] != 0 {
+        return
+    }
+    if e.CheckPlacement(s.positions) != PLACE_OK {
+        return
+    }
+    words := e.FindWords(s.tiles, s.positions)
+    if len(words) == 0 {
+        return
+    }
+    for _, w := range words {
+        if !w.IsValid {
+            return
+        }
+    }
+
+    id := getMoveId(s.tiles, s.positions)
+    if s.seen[id] {
+        return
+    }
+    s.seen[id] = true
+
+    move := Move{MOVE_PLAY, s.player, nil, nil}
+    move.Tiles = append(move.Tiles, s.tiles...)
+    move.Positions = append(move.Positions, s.positions...)
+    s.moves = append(s.moves, move)
+}
+
+// a single tile can be found along both the row and the column, so moves are compared by where each tile ends up
+func getMoveId(tiles []int16, positions []int) string {
+    parts := make([]string, len(tiles))
+    for i := range tiles {
+        parts[i] = strconv.Itoa(positions[i]) + ":" + strconv.Itoa(int(tiles[i]))
+    }
+    sort.Strings(parts)
+    return strings.Join(parts, ",")
+}
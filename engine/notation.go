@@ -0,0 +1,260 @@
+package engine
+
+import "errors"
+import "strconv"
+import "strings"
+import "unicode"
+import "unicode/utf8"
+
+// columns are lettered from A and rows are numbered from 1, so the top left square is A1.
+// a horizontal play starts with its row ("8H QUIZ") and a vertical one starts with its column ("H8 QUIZ").
+// lowercase letters are blanks, and letters already on the board are either written as '.' or put in brackets
+
+func FormatColumn(x int) string {
+    return string(rune('A' + x))
+}
+
+func FormatCoord(pos, size int, isVertical bool) string {
+    col := FormatColumn(pos % size)
+    row := strconv.Itoa(pos / size + 1)
+    if isVertical {
+        return col + row
+    }
+    return row + col
+}
+
+// returns the cell named by the coordinate, and whether a play starting there goes down the board
+func ParseCoord(text string, size int) (pos int, isVertical bool, err error) {
+    text = strings.ToUpper(text)
+    if len(text) < 2 {
+        return 0, false, errors.New("\"" + text + "\" isn't a square on the board")
+    }
+
+    isVertical = text[0] < '0' || text[0] > '9'
+    colText := text[len(text)-1:]
+    rowText := text[:len(text)-1]
+    if isVertical {
+        colText = text[:1]
+        rowText = text[1:]
+    }
+
+    col := int(colText[0]) - 'A'
+    row, err := strconv.Atoi(rowText)
+    if err != nil || col < 0 || col >= size || row < 1 || row > size {
+        return 0, false, errors.New("\"" + text + "\" isn't a square on the board")
+    }
+    return col + size * (row - 1), isVertical, nil
+}
+
+// finds the longest letter at the start of the text, in either case. a lowercase letter is a blank
+func (e *Engine) matchLetter(text string) (tile int16, n int) {
+    for i := 0; i < e.GetLetterCount(); i++ {
+        letter := e.TileSet[i].Letter
+        if len(letter) > n && len(text) >= len(letter) && strings.EqualFold(text[:len(letter)], letter) {
+            tile = int16(i + 1)
+            n = len(letter)
+        }
+    }
+    if r, _ := utf8.DecodeRuneInString(text); tile != 0 && unicode.IsLower(r) {
+        tile |= BLANK_FLAG
+    }
+    return tile, n
+}
+
+// the text for a tile as it's written in a move, with a blank in lowercase
+func (e *Engine) formatTile(tile int16) string {
+    if tile == e.BlankTile {
+        return "?"
+    }
+    letter := e.Lexicon.GetLetter(tile)
+    if (tile & BLANK_FLAG) != 0 {
+        return strings.ToLower(letter)
+    }
+    return letter
+}
+
+// a pass is written as "-" and an exchange as "-" followed by the tiles that went back in the bag.
+// it doesn't matter whether the play has been applied to the board yet. letters already on the board are put in brackets,
+// or written as '.' if isDotted is set, which is how GCG records have them
+func (e *Engine) FormatMove(move Move, isDotted bool) (string, error) {
+    return e.formatMove(move, e.Board, isDotted)
+}
+
+// board is the board that the play is made on, which for a play in the history isn't the board as it is now
+func (e *Engine) formatMove(move Move, board []int16, isDotted bool) (string, error) {
+    if move.Kind == MOVE_PASS {
+        return "-", nil
+    }
+    if move.Kind == MOVE_EXCHANGE {
+        text := "-"
+        for _, tile := range move.Tiles {
+            text += e.formatTile(tile)
+        }
+        return text, nil
+    }
+    if move.Kind != MOVE_PLAY || len(move.Positions) == 0 || len(move.Tiles) != len(move.Positions) {
+        return "", errors.New("Only plays, exchanges and passes can be written down")
+    }
+
+    size := e.Layout.Size
+    findNew := func(pos int) int {
+        for i, p := range move.Positions {
+            if p == pos {
+                return i
+            }
+        }
+        return -1
+    }
+    isTaken := func(x, y int) bool {
+        if x < 0 || y < 0 || x >= size || y >= size {
+            return false
+        }
+        return findNew(x + size * y) >= 0 || board[x + size * y] != 0
+    }
+
+    // a single tile goes whichever way it makes a word, preferring across
+    start := move.Positions[0]
+    for _, pos := range move.Positions {
+        start = min(start, pos)
+    }
+    x := start % size
+    y := start / size
+    isVertical := len(move.Positions) > 1 && move.Positions[0] % size == move.Positions[1] % size
+    if len(move.Positions) == 1 {
+        isVertical = !isTaken(x - 1, y) && !isTaken(x + 1, y) && (isTaken(x, y - 1) || isTaken(x, y + 1))
+    }
+
+    dx, dy := 1, 0
+    if isVertical {
+        dx, dy = 0, 1
+    }
+    for isTaken(x - dx, y - dy) {
+        x -= dx
+        y -= dy
+    }
+
+    text := FormatCoord(x + size * y, size, isVertical) + " "
+    inBrackets := false
+    for ; isTaken(x, y); x, y = x + dx, y + dy {
+        pos := x + size * y
+        idx := findNew(pos)
+        if idx < 0 && isDotted {
+            text += "."
+            continue
+        }
+        if idx < 0 && !inBrackets {
+            text += "("
+        } else if idx >= 0 && inBrackets {
+            text += ")"
+        }
+        inBrackets = idx < 0
+
+        if idx >= 0 {
+            text += e.formatTile(move.Tiles[idx])
+        } else {
+            text += e.formatTile(board[pos])
+        }
+    }
+    if inBrackets {
+        text += ")"
+    }
+    return text, nil
+}
+
+// letters that are already on the board don't have to be marked, as long as they match what's there
+func (e *Engine) ParseMove(player int32, text string) (Move, error) {
+    move := Move{MOVE_PLAY, player, nil, nil}
+    text = strings.TrimSpace(text)
+    if text == "-" {
+        move.Kind = MOVE_PASS
+        return move, nil
+    }
+
+    if strings.HasPrefix(text, "-") {
+        move.Kind = MOVE_EXCHANGE
+        for rest := text[1:]; len(rest) > 0; {
+            if rest[0] == '?' {
+                move.Tiles = append(move.Tiles, e.BlankTile)
+                rest = rest[1:]
+                continue
+            }
+            tile, n := e.matchLetter(rest)
+            if tile == 0 {
+                return move, errors.New("\"" + rest + "\" doesn't start with a letter in the tile set")
+            }
+            move.Tiles = append(move.Tiles, tile & LETTER_MASK)
+            rest = rest[n:]
+        }
+        return move, nil
+    }
+
+    fields := strings.Fields(text)
+    if len(fields) != 2 {
+        return move, errors.New("A play should be a square and a word, like \"8H QUIZ\"")
+    }
+    size := e.Layout.Size
+    start, isVertical, err := ParseCoord(fields[0], size)
+    if err != nil {
+        return move, err
+    }
+
+    dx, dy := 1, 0
+    if isVertical {
+        dx, dy = 0, 1
+    }
+    x := start % size
+    y := start / size
+    inBrackets := false
+
+    for word := fields[1]; len(word) > 0; {
+        if word[0] == '(' || word[0] == ')' {
+            if inBrackets == (word[0] == '(') {
+                return move, errors.New("The brackets in \"" + fields[1] + "\" don't match")
+            }
+            inBrackets = !inBrackets
+            word = word[1:]
+            continue
+        }
+        if x >= size || y >= size {
+            return move, errors.New("\"" + text + "\" runs off the board")
+        }
+        pos := x + size * y
+        coord := FormatCoord(pos, size, false)
+        x += dx
+        y += dy
+
+        if word[0] == '.' {
+            if e.Board[pos] == 0 {
+                return move, errors.New("There's no tile on " + coord + " to play through")
+            }
+            word = word[1:]
+            continue
+        }
+
+        tile, n := e.matchLetter(word)
+        if tile == 0 {
+            return move, errors.New("\"" + word + "\" doesn't start with a letter in the tile set")
+        }
+        word = word[n:]
+
+        if e.Board[pos] != 0 {
+            if (e.Board[pos] & LETTER_MASK) != (tile & LETTER_MASK) {
+                return move, errors.New("The tile on " + coord + " is " + e.formatTile(e.Board[pos]) + ", not " + e.formatTile(tile))
+            }
+            continue
+        }
+        if inBrackets {
+            return move, errors.New("There's no tile on " + coord + " to play through")
+        }
+        move.Tiles = append(move.Tiles, tile)
+        move.Positions = append(move.Positions, pos)
+    }
+
+    if inBrackets {
+        return move, errors.New("The brackets in \"" + fields[1] + "\" don't match")
+    }
+    if len(move.Tiles) == 0 {
+        return move, errors.New("\"" + text + "\" doesn't put down any new tiles")
+    }
+    return move, nil
+}
@@ -0,0 +1,96 @@
+package engine
+
+import "testing"
+
+func TestCoords(t *testing.T) {
+    cases := []struct {
+        text string
+        pos int
+        isVertical bool
+    }{
+        {"8H", 112, false},
+        {"H8", 112, true},
+        {"1A", 0, false},
+        {"o15", 224, true},
+    }
+    for _, c := range cases {
+        pos, isVertical, err := ParseCoord(c.text, 15)
+        if err != nil || pos != c.pos || isVertical != c.isVertical {
+            t.Errorf("%s should be %d (vertical %v), got %d (vertical %v) %v", c.text, c.pos, c.isVertical, pos, isVertical, err)
+        }
+    }
+    if FormatCoord(112, 15, false) != "8H" || FormatCoord(112, 15, true) != "H8" {
+        t.Error("H8 isn't formatted properly")
+    }
+    for _, text := range []string{"", "8", "16A", "A16", "P1", "0A"} {
+        if _, _, err := ParseCoord(text, 15); err == nil {
+            t.Errorf("\"%s\" isn't a square on the board", text)
+        }
+    }
+}
+
+func TestFormatAndParseMoves(t *testing.T) {
+    e := makeTestEngine(t)
+    cat := makeTiles(t, e, "CAT")
+    for i, pos := range []int{111, 112, 113} {
+        e.Board[pos] = cat[i]
+    }
+
+    cases := []struct {
+        move Move
+        text string
+        dotted string
+    }{
+        {Move{MOVE_PLAY, 0, makeTiles(t, e, "TE"), []int{97, 127}}, "H7 T(A)E", "H7 T.E"},
+        {Move{MOVE_PLAY, 0, makeTiles(t, e, "S"), []int{114}}, "8G (CAT)S", "8G ...S"},
+        {Move{MOVE_PLAY, 0, makeTiles(t, e, "E"), []int{127}}, "H8 (A)E", "H8 .E"},       // a single tile goes the way it makes a word
+        {Move{MOVE_PLAY, 0, makeTiles(t, e, "Ax"), []int{124, 125}}, "9E Ax", "9E Ax"},
+        {Move{MOVE_PASS, 0, nil, nil}, "-", "-"},
+        {Move{MOVE_EXCHANGE, 0, []int16{1, 2, e.BlankTile}, nil}, "-AB?", "-AB?"},
+    }
+    for _, c := range cases {
+        text, err := e.FormatMove(c.move, false)
+        if err != nil || text != c.text {
+            t.Errorf("%v should be written as %s, got %s %v", c.move, c.text, text, err)
+        }
+        dotted, err := e.FormatMove(c.move, true)
+        if err != nil || dotted != c.dotted {
+            t.Errorf("%v should be written as %s with dots, got %s %v", c.move, c.dotted, dotted, err)
+        }
+        if move, err := e.ParseMove(0, c.dotted); err != nil || len(move.Tiles) != len(c.move.Tiles) {
+            t.Errorf("%s should read back as %v, got %v %v", c.dotted, c.move, move, err)
+        }
+
+        move, err := e.ParseMove(0, c.text)
+        if err != nil {
+            t.Errorf("%s: %v", c.text, err)
+            continue
+        }
+        if move.Kind != c.move.Kind || len(move.Tiles) != len(c.move.Tiles) || len(move.Positions) != len(c.move.Positions) {
+            t.Errorf("%s should read back as %v, got %v", c.text, c.move, move)
+            continue
+        }
+        for i := range move.Tiles {
+            if move.Tiles[i] != c.move.Tiles[i] || (len(move.Positions) > 0 && move.Positions[i] != c.move.Positions[i]) {
+                t.Errorf("%s should read back as %v, got %v", c.text, c.move, move)
+            }
+        }
+    }
+
+    // the tiles already on the board can be written any of these ways
+    for _, text := range []string{"H7 TAE", "h7 T.E", "H7 T(A)E"} {
+        move, err := e.ParseMove(0, text)
+        if err != nil || len(move.Positions) != 2 || move.Positions[0] != 97 || move.Positions[1] != 127 {
+            t.Errorf("%s should put down T on H7 and E on H9, got %v %v", text, move, err)
+        }
+    }
+
+    for _, text := range []string{"8H", "Z99 CAT", "8G DOG", "7G (C)AT", "8M CATSS", "H7 T(AE", "8G (CAT)", "-A1"} {
+        if _, err := e.ParseMove(0, text); err == nil {
+            t.Errorf("\"%s\" shouldn't be read as a move", text)
+        }
+    }
+    if _, err := e.FormatMove(Move{MOVE_CHALLENGE, 0, nil, nil}, false); err == nil {
+        t.Error("a challenge can't be written as a move")
+    }
+}
@@ -0,0 +1,187 @@
+package engine
+
+import "errors"
+import "strconv"
+import "strings"
+
+// the state of a game in progress is a few lines of a name followed by numbers, then the game record.
+// the record puts the board and the scores back, and the rest is what can't be worked out from it:
+//   BoardSize 15
+//   Seed 1234                 the seed and how many tiles have been drawn, so the draws carry on the same way
+//   Draws 23
+//   Turn 1
+//   ScorelessTurns 0
+//   SkipNextTurn 0 0 0 0
+//   Bag 5 1 27 ...            the tiles in the bag, in order
+//   Rack1 3 9 12 ...          the tiles on each active player's rack
+// the challenge for the last play isn't kept, so a state should be written between turns
+
+func formatNumbers(name string, values []int16) string {
+    text := name
+    for _, v := range values {
+        text += " " + strconv.Itoa(int(v))
+    }
+    return text + "\n"
+}
+
+func (e *Engine) WriteState() string {
+    var builder strings.Builder
+    builder.WriteString("BoardSize " + strconv.Itoa(e.Layout.Size) + "\n")
+    builder.WriteString("Seed " + strconv.FormatUint(e.Seed, 10) + "\n")
+    builder.WriteString("Draws " + strconv.FormatUint(e.nDraws, 10) + "\n")
+    builder.WriteString("Turn " + strconv.Itoa(int(e.Turn)) + "\n")
+    builder.WriteString("ScorelessTurns " + strconv.Itoa(int(e.ScorelessTurns)) + "\n")
+
+    var skips [4]int16
+    for i := 0; i < 4; i++ {
+        if e.SkipNextTurn[i] {
+            skips[i] = 1
+        }
+    }
+    builder.WriteString(formatNumbers("SkipNextTurn", skips[:]))
+    builder.WriteString(formatNumbers("Bag", e.Bag))
+    for i := 0; i < 4; i++ {
+        if e.IsActive[i] {
+            builder.WriteString(formatNumbers("Rack" + strconv.Itoa(i + 1), e.Racks[i]))
+        }
+    }
+
+    builder.WriteString(e.WriteGCG())
+    return builder.String()
+}
+
+var stateNames = [...]string{"BoardSize", "Seed", "Draws", "Turn", "ScorelessTurns", "SkipNextTurn", "Bag"}
+
+func isStateLine(name string) bool {
+    for _, n := range stateNames {
+        if n == name {
+            return true
+        }
+    }
+    return strings.HasPrefix(name, "Rack")
+}
+
+func stateLineError(n int, msg string) error {
+    return errors.New("line " + strconv.Itoa(n + 1) + " of the saved game " + msg)
+}
+
+// the rules settings (rack size, challenges and so on) aren't part of the state, so they should be set beforehand.
+// lines with other names are left for the front end. nothing is changed unless the whole state can be read
+func (e *Engine) ReadState(lines []string) error {
+    loaded := *e
+    loaded.Board = make([]int16, len(e.Board))
+    loaded.Racks = [4][]int16{}
+    loaded.Bag = nil
+    loaded.SkipNextTurn = [4]bool{}
+
+    err := loaded.ReadGCG(lines)
+    if err != nil {
+        return err
+    }
+
+    found := make(map[string]bool)
+    for n, l := range lines {
+        fields := strings.Fields(l)
+        if len(fields) == 0 || !isStateLine(fields[0]) {
+            continue
+        }
+        name := fields[0]
+        found[name] = true
+
+        if name == "Seed" || name == "Draws" {
+            if len(fields) != 2 {
+                return stateLineError(n, "should be " + name + " followed by a number")
+            }
+            value, err := strconv.ParseUint(fields[1], 10, 64)
+            if err != nil {
+                return stateLineError(n, "should be " + name + " followed by a number")
+            }
+            if name == "Seed" {
+                loaded.Seed = value
+            } else {
+                loaded.nDraws = value
+            }
+            continue
+        }
+
+        var values []int16
+        for _, f := range fields[1:] {
+            v, err := strconv.Atoi(f)
+            if err != nil || v < 0 || v > 0x7fff {
+                return stateLineError(n, "has \"" + f + "\", which isn't a number")
+            }
+            values = append(values, int16(v))
+        }
+
+        if name == "BoardSize" {
+            if len(values) != 1 || int(values[0]) != e.Layout.Size {
+                return stateLineError(n, "is for a different board, not one that's " + strconv.Itoa(e.Layout.Size) + " squares across")
+            }
+        } else if name == "Turn" {
+            if len(values) != 1 || values[0] > 3 || !loaded.IsActive[values[0]] {
+                return stateLineError(n, "should be Turn followed by an active player from 0 to 3")
+            }
+            loaded.Turn = int32(values[0])
+        } else if name == "ScorelessTurns" {
+            if len(values) != 1 {
+                return stateLineError(n, "should be ScorelessTurns followed by a number")
+            }
+            loaded.ScorelessTurns = int32(values[0])
+        } else if name == "SkipNextTurn" {
+            if len(values) != 4 {
+                return stateLineError(n, "should be SkipNextTurn followed by 4 numbers")
+            }
+            for i := 0; i < 4; i++ {
+                loaded.SkipNextTurn[i] = values[i] != 0
+            }
+        } else if name == "Bag" || strings.HasPrefix(name, "Rack") {
+            for _, v := range values {
+                if v == 0 || v > e.BlankTile {
+                    return stateLineError(n, "has " + strconv.Itoa(int(v)) + ", which isn't a tile in the tile set")
+                }
+            }
+            if name == "Bag" {
+                loaded.Bag = values
+                continue
+            }
+            idx, err := strconv.Atoi(name[len("Rack"):])
+            if err != nil || idx < 1 || idx > 4 || !loaded.IsActive[idx - 1] || len(values) > e.RackSize {
+                return stateLineError(n, "should be the rack of an active player, with at most " + strconv.Itoa(e.RackSize) + " tiles")
+            }
+            loaded.Racks[idx - 1] = values
+        }
+    }
+
+    for _, name := range stateNames {
+        if !found[name] {
+            return errors.New("the saved game is missing its " + name + " line")
+        }
+    }
+
+    // every tile in the set should be somewhere, or the bag and the racks don't belong to this game
+    var counts [MAX_LETTERS + 2]int32
+    for _, tile := range loaded.Board {
+        if (tile & BLANK_FLAG) != 0 {
+            tile = e.BlankTile
+        }
+        counts[tile]++
+    }
+    for _, tile := range loaded.Bag {
+        counts[tile]++
+    }
+    for i := 0; i < 4; i++ {
+        for _, tile := range loaded.Racks[i] {
+            counts[tile]++
+        }
+    }
+    for i := 0; i < len(e.TileSet); i++ {
+        if counts[i + 1] != e.TileSet[i].Count {
+            return errors.New("the saved game doesn't have the same tiles as the tile set, it has " +
+                strconv.Itoa(int(counts[i + 1])) + " of " + e.formatTile(int16(i + 1)) + " instead of " + strconv.Itoa(int(e.TileSet[i].Count)))
+        }
+    }
+
+    loaded.IsOver = false
+    *e = loaded
+    return nil
+}
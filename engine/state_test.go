@@ -0,0 +1,75 @@
+package engine
+
+import "reflect"
+import "strings"
+import "testing"
+
+// partway through a game, with player 2 to lose their next turn
+func makeStateEngine(t *testing.T) *Engine {
+    e := makeTestEngine(t, "CAT")
+    e.Seed = 1234
+    e.Reset()
+    e.Deal(0)
+    setRack(t, e, 0, "CATEEEE")
+    play(t, e, 0, "CAT", 111, 112, 113)
+    e.SkipNextTurn[1] = true
+    return e
+}
+
+func TestStateRoundTrip(t *testing.T) {
+    e := makeStateEngine(t)
+    r := makeTestEngine(t, "CAT")
+    err := r.ReadState(strings.Split(e.WriteState(), "\n"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if !reflect.DeepEqual(r.Board, e.Board) || !reflect.DeepEqual(r.Bag, e.Bag) || r.Scores != e.Scores {
+        t.Error("the board, the bag and the scores should be read back")
+    }
+    for i := 0; i < 2; i++ {
+        if !reflect.DeepEqual(r.Racks[i], e.Racks[i]) {
+            t.Errorf("rack %d should be %v, got %v", i + 1, e.Racks[i], r.Racks[i])
+        }
+    }
+    if r.Turn != e.Turn || r.ScorelessTurns != e.ScorelessTurns || r.SkipNextTurn != e.SkipNextTurn || r.IsOver {
+        t.Errorf("the turn should be read back, got turn %d", r.Turn)
+    }
+
+    // the game carries on with the same draws
+    for i := 0; i < 5; i++ {
+        if a, b := e.TakeTile(), r.TakeTile(); a != b {
+            t.Fatalf("draw %d should be the same after reading the state back, got %d and %d", i + 1, a, b)
+        }
+    }
+}
+
+func TestReadStateErrors(t *testing.T) {
+    e := makeStateEngine(t)
+    text := e.WriteState()
+    bag := "Bag" + formatNumbers("", e.Bag)
+
+    bad := map[string]string{
+        "a different board": strings.Replace(text, "BoardSize 15", "BoardSize 11", 1),
+        "no seed": strings.Replace(text, "Seed 1234\n", "", 1),
+        "a seed that isn't a number": strings.Replace(text, "Seed 1234", "Seed abc", 1),
+        "an extra tile in the bag": strings.Replace(text, bag, strings.TrimSuffix(bag, "\n") + " 1\n", 1),
+        "a tile not in the set": strings.Replace(text, bag, strings.TrimSuffix(bag, "\n") + " 99\n", 1),
+        "a rack for a player not playing": text + "Rack3 1 2\n",
+        "the turn of a player not playing": strings.Replace(text, "Turn 1", "Turn 2", 1),
+        "a broken record": text + ">p3: CATEEEE 8G CAT +10 10\n",
+    }
+    for what, badText := range bad {
+        if badText == text {
+            t.Fatalf("the state with %s is the same as the good one", what)
+        }
+        r := makeTestEngine(t, "CAT")
+        before := r.WriteState()
+        if err := r.ReadState(strings.Split(badText, "\n")); err == nil {
+            t.Errorf("a state with %s shouldn't be read", what)
+        }
+        if r.WriteState() != before {
+            t.Errorf("a state with %s shouldn't change the game", what)
+        }
+    }
+}
@@ -0,0 +1,213 @@
+package engine
+
+import "errors"
+import "strconv"
+import "strings"
+import "unicode"
+
+// the blank is always the last tile in a set. Key is the character typed to pick up the tile
+type Tile struct {
+	Letter string
+	Points int32
+	Count int32
+	Key rune
+}
+
+// a blank that has been given a letter is stored as that letter's tile with this flag set
+const BLANK_FLAG = 0x100
+const LETTER_MASK = 0xff
+const MAX_LETTERS = 100
+
+const MIN_RACK_SIZE = 5
+const MAX_RACK_SIZE = 9
+
+var DefaultTiles = [...]Tile {
+	{"A", 1, 9, 'A'},
+	{"B", 3, 2, 'B'},
+	{"C", 3, 2, 'C'},
+	{"D", 2, 4, 'D'},
+	{"E", 1, 12, 'E'},
+	{"F", 4, 2, 'F'},
+	{"G", 2, 3, 'G'},
+	{"H", 4, 2, 'H'},
+	{"I", 1, 9, 'I'},
+	{"J", 8, 1, 'J'},
+	{"K", 5, 1, 'K'},
+	{"L", 1, 4, 'L'},
+	{"M", 3, 2, 'M'},
+	{"N", 1, 6, 'N'},
+	{"O", 1, 8, 'O'},
+	{"P", 3, 2, 'P'},
+	{"Q", 10, 1, 'Q'},
+	{"R", 1, 6, 'R'},
+	{"S", 1, 4, 'S'},
+	{"T", 1, 6, 'T'},
+	{"U", 1, 4, 'U'},
+	{"V", 4, 2, 'V'},
+	{"W", 4, 2, 'W'},
+	{"X", 8, 1, 'X'},
+	{"Y", 4, 2, 'Y'},
+	{"Z", 10, 1, 'Z'},
+	{"?", 0, 2, '?'},
+}
+
+// each line is a letter, its points, how many are in the bag, and optionally the key used to pick it up.
+// letters can be more than one character (eg. CH or LL), but then they need a key. '?' stands for the blank
+func ParseTileSet(lines []string) ([]Tile, error) {
+    set := make([]Tile, 0, 32)
+    blank := Tile{"?", 0, 0, '?'}
+
+    for n, l := range lines {
+        fields := strings.Fields(l)
+        if len(fields) == 0 || fields[0][0] == '#' {
+            continue
+        }
+        if len(fields) != 3 && len(fields) != 4 {
+            return nil, errors.New("line " + strconv.Itoa(n + 1) + " of the tile set should be a letter, its points, its count and optionally its key")
+        }
+
+        points, err := strconv.Atoi(fields[1])
+        if err != nil || points < 0 || points > 99 {
+            return nil, errors.New("the points on line " + strconv.Itoa(n + 1) + " of the tile set should be between 0 and 99")
+        }
+        count, err := strconv.Atoi(fields[2])
+        if err != nil || count < 0 {
+            return nil, errors.New("the count on line " + strconv.Itoa(n + 1) + " of the tile set should be a positive number")
+        }
+
+        if fields[0] == "?" || fields[0] == "_" {
+            blank.Points = int32(points)
+            blank.Count = int32(count)
+            continue
+        }
+
+        tile := Tile{strings.ToUpper(fields[0]), int32(points), int32(count), 0}
+        runes := []rune(tile.Letter)
+        if len(fields) == 4 {
+            keys := []rune(fields[3])
+            if len(keys) != 1 {
+                return nil, errors.New("the key on line " + strconv.Itoa(n + 1) + " of the tile set should be a single character")
+            }
+            tile.Key = unicode.ToUpper(keys[0])
+        } else if len(runes) == 1 {
+            tile.Key = runes[0]
+        } else {
+            return nil, errors.New("the letter \"" + tile.Letter + "\" on line " + strconv.Itoa(n + 1) + " of the tile set needs a key")
+        }
+
+        set = append(set, tile)
+        if len(set) > MAX_LETTERS {
+            return nil, errors.New("a tile set can have at most " + strconv.Itoa(MAX_LETTERS) + " letters")
+        }
+    }
+
+    return append(set, blank), nil
+}
+
+func (e *Engine) GetLetterCount() int {
+    return len(e.TileSet) - 1
+}
+
+// the points for a tile on the board or the rack. a blank is worth nothing whatever letter it stands for
+func (e *Engine) GetPoints(tile int16) int32 {
+    if tile <= 0 || (tile & BLANK_FLAG) != 0 {
+        return 0
+    }
+    return e.TileSet[tile - 1].Points
+}
+
+func (e *Engine) fillBag() {
+    e.Bag = e.Bag[0:0]
+    for i := 0; i < len(e.TileSet); i++ {
+        for j := 0; j < int(e.TileSet[i].Count); j++ {
+            e.Bag = append(e.Bag, int16(i + 1))
+        }
+    }
+}
+
+// splitmix64, taking the seed and the number of draws so far as its state
+func (e *Engine) random(endExclusive int64) int64 {
+    e.nDraws++
+    z := e.Seed + e.nDraws * 0x9e3779b97f4a7c15
+    z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+    z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+    z ^= z >> 31
+    return int64(z % uint64(endExclusive))
+}
+
+// returns 0 if the bag is empty
+func (e *Engine) TakeTile() int16 {
+    if len(e.Bag) == 0 {
+        return 0
+    }
+
+    idx := int(e.random(int64(len(e.Bag))))
+    tile := e.Bag[idx]
+    e.Bag[idx] = e.Bag[len(e.Bag)-1]
+    e.Bag = e.Bag[:len(e.Bag)-1]
+    return tile
+}
+
+func (e *Engine) PutTile(tile int16) {
+    if (tile & BLANK_FLAG) != 0 {
+        tile = e.BlankTile
+    }
+    e.Bag = append(e.Bag, tile)
+}
+
+func (e *Engine) GetRackValue(player int32) int32 {
+    value := int32(0)
+    for _, tile := range e.Racks[player] {
+        value += e.GetPoints(tile)
+    }
+    return value
+}
+
+// a blank given a letter comes off the rack as the blank tile
+func (e *Engine) removeFromRack(player int32, tile int16) bool {
+    if (tile & BLANK_FLAG) != 0 {
+        tile = e.BlankTile
+    }
+    rack := e.Racks[player]
+    for i := 0; i < len(rack); i++ {
+        if rack[i] == tile {
+            e.Racks[player] = append(rack[:i], rack[i+1:]...)
+            return true
+        }
+    }
+    return false
+}
+
+// checks that every tile is on the rack, counting duplicates
+func (e *Engine) hasTiles(player int32, tiles []int16) bool {
+    var counts [MAX_LETTERS + 2]int32
+    for _, tile := range e.Racks[player] {
+        counts[tile]++
+    }
+    for _, tile := range tiles {
+        if (tile & BLANK_FLAG) != 0 {
+            letter := tile & LETTER_MASK
+            if letter <= 0 || int(letter) > e.GetLetterCount() {
+                return false
+            }
+            tile = e.BlankTile
+        }
+        if tile <= 0 || tile > e.BlankTile || counts[tile] == 0 {
+            return false
+        }
+        counts[tile]--
+    }
+    return true
+}
+
+func (e *Engine) fillRack(player int32) (drawn []int16) {
+    for len(e.Racks[player]) < e.RackSize {
+        tile := e.TakeTile()
+        if tile == 0 {
+            break
+        }
+        e.Racks[player] = append(e.Racks[player], tile)
+        drawn = append(drawn, tile)
+    }
+    return drawn
+}
@@ -0,0 +1,49 @@
+package engine
+
+import "errors"
+import "strconv"
+import "strings"
+
+// every file the game writes says which version of its format it's in. one from an older version is brought up to date
+// by going through each migration after its version in turn: migrations[0] turns version 1 into version 2, and so on.
+// keeping the migrations in an array of [VERSION - 1] means there's a place for each one, but a place left empty
+// still compiles, so Migrate refuses to go past a missing migration rather than reading the file as it is
+type Migration func(lines []string) ([]string, error)
+
+// returns 0 if no line starts with the prefix
+func FindVersion(lines []string, prefix string) (int, error) {
+    for _, l := range lines {
+        l = strings.TrimSpace(l)
+        if !strings.HasPrefix(l, prefix) {
+            continue
+        }
+        version, err := strconv.Atoi(strings.TrimSpace(l[len(prefix):]))
+        if err != nil || version < 1 {
+            return 0, errors.New("\"" + l + "\" should be followed by a version number")
+        }
+        return version, nil
+    }
+    return 0, nil
+}
+
+// name is what the file is, for the errors
+func Migrate(name string, lines []string, version int, migrations []Migration) ([]string, error) {
+    current := len(migrations) + 1
+    if version > current {
+        return nil, errors.New("The " + name + " is from a newer version of the game. It's version " + strconv.Itoa(version) +
+            ", but only versions up to " + strconv.Itoa(current) + " can be read")
+    }
+
+    for v := version; v < current; v++ {
+        if migrations[v - 1] == nil {
+            return nil, errors.New("The " + name + " is version " + strconv.Itoa(version) + ", but there's no way to update it from version " +
+                strconv.Itoa(v))
+        }
+        var err error
+        lines, err = migrations[v - 1](lines)
+        if err != nil {
+            return nil, errors.New("The " + name + " couldn't be updated from version " + strconv.Itoa(v) + ": " + err.Error())
+        }
+    }
+    return lines, nil
+}
@@ -0,0 +1,39 @@
+package engine
+
+import "testing"
+
+func TestMigrate(t *testing.T) {
+    addLine := func(lines []string) ([]string, error) {
+        return append(lines, "added"), nil
+    }
+    migrations := []Migration{addLine, addLine}
+
+    lines, err := Migrate("test file", []string{"Version 1"}, 1, migrations)
+    if err != nil || len(lines) != 3 {
+        t.Errorf("version 1 should go through both migrations, got %v %v", lines, err)
+    }
+    lines, err = Migrate("test file", []string{"Version 3"}, 3, migrations)
+    if err != nil || len(lines) != 1 {
+        t.Errorf("the current version shouldn't be changed, got %v %v", lines, err)
+    }
+    if _, err := Migrate("test file", nil, 4, migrations); err == nil {
+        t.Error("a file from a newer version shouldn't be read")
+    }
+    if _, err := Migrate("test file", nil, 1, []Migration{addLine, nil}); err == nil {
+        t.Error("a file shouldn't be read past a missing migration")
+    }
+}
+
+func TestFindVersion(t *testing.T) {
+    version, err := FindVersion([]string{"# notes", "Version 2"}, "Version ")
+    if err != nil || version != 2 {
+        t.Errorf("the version should be 2, got %d %v", version, err)
+    }
+    version, err = FindVersion([]string{"# notes"}, "Version ")
+    if err != nil || version != 0 {
+        t.Errorf("there's no version, got %d %v", version, err)
+    }
+    if _, err := FindVersion([]string{"Version two"}, "Version "); err == nil {
+        t.Error("the version should be a number")
+    }
+}
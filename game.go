@@ -1,8 +1,9 @@
 package main
 
-//import "fmt"
+import "os"
 import "strconv"
-import "strings"
+import "unicode"
+import "scrambles/engine"
 
 type Animation struct {
     prev uint64
@@ -12,17 +13,31 @@ type Animation struct {
 }
 
 type MainMenu struct {
+	gameMode int32
+	challengeRule int32
+	challengePoints int
+	clockMode int32
 	timeLimitSecs int
+	incrementSecs int
+	overtimePenalty int
+	scorelessTurnLimit int
+	rackSize int
+	seed int
 	shouldValidateEveryWord bool
 }
 
 type Player struct {
     kind int32
-    totalScore int32
+    totalScore int32 // the score as shown, which catches up with the rules once the turn has been scored
     turnScore int32
+    clockFrames int32
+    overtimeFrames int32
     nTilesHeld int32
-	turnLetters [7]int8
-	turnPositions [7]uint8
+	turnLetters [engine.MAX_RACK_SIZE]int16
+	turnPositions [engine.MAX_RACK_SIZE]uint16
+	nTilesStaged int32
+	stagedLetters [engine.MAX_RACK_SIZE]int16
+	stagedPositions [engine.MAX_RACK_SIZE]uint16
 	turnState Animation
 	turnOffsetsBits Animation
 	deckTilesBits Animation
@@ -41,6 +56,7 @@ type Inputs struct {
 
 type Game struct {
 	menu MainMenu
+	rules engine.Engine
 	players [4]Player
 	state Animation
 
@@ -50,20 +66,45 @@ type Game struct {
     cursorDuringMoveY int32
 
     shuffleTimer int32
-    shuffleBuf [14]int8
+    shuffleBuf [2 * engine.MAX_RACK_SIZE]int8
 
-	bagMap []int32
-	bagChars []byte
-	wordsList []string
-	wordMap map[string]int
-	boardTiles []int8
+    orderTiles [4]int16
+    orderContenders int32
 
-    activeLines []uint16
-	scoringWords []string
-	scoringCommands []uint16
-	wordBuilder strings.Builder
+    isResultsDismissed bool
 
-    scoreDisplayStrings []string
+    isExchanging bool
+    exchangeSlots int32
+
+    isChallengeable bool
+    rackBeforeDraw uint64
+    skippedPlayer int32
+
+    isDragging bool
+    dragStartX int32
+    dragStartY int32
+    isChoosingBlank bool
+
+    isReplay bool
+    replayPos int
+    replay []string // the game record from the config, kept so that it can be watched again after a game
+
+    isMenuOpen bool
+    hasAutosave bool
+    autosaveSlot int
+    isAutosaveCurrent bool // until a new game has been saved once, the autosaves are still the last unfinished game's
+    isAutosaveFailing bool
+
+	keyMap map[rune]int16
+
+    turnTiles []int16
+    turnCells []int
+    previewWords []engine.Word
+    invalidWords []engine.Word
+	scoringCommands []ScoringCommand
+
+    message string
+    messageTimer int32
 
 	startupTimestamp int64
 	prevHash64 uint64
@@ -75,23 +116,35 @@ type Game struct {
 	tileSize int32
 }
 
-type Tile struct {
-	letter int32
-	points int32
-	count int32
+type Rect struct {
+    x, y, w, h int32
 }
 
-const NORMAL = 0
-const DOUBLE_WORD = 1
-const TRIPLE_WORD = 2
-const DOUBLE_LETTER = 3
-const TRIPLE_LETTER = 4
-const ALL_LETTERS = 5
-const BONUS = 0x40
+type DeckLayout struct {
+    rect Rect
+    tileSize float32
+    tilePad float32
+    sidePad int32
+}
+
+// each step of the score is shown next to its board cell, as points to add ("+3") or a multiplier ("x2").
+// the text is made when the turn is scored, since a tile set can have letters worth any number of points
+type ScoringCommand struct {
+    pos int
+    text string
+}
+
+// the rack is packed into a uint64 with 7 bits per slot, which leaves room for 9 tiles
+const RACK_SLOT_BITS = 7
 
 const PICK_ORDER = 4
 const PLAYER_TURN = 8
 const SCORING_TURN = 12
+const GAME_OVER = 16
+const REPLAY = 20
+
+// a finished game is written out here, so it can be looked over in other programs
+const RECORD_FILE = "last-game.gcg"
 
 const ROTA_VERT = 0
 const ROTA_HORI = 1
@@ -101,165 +154,140 @@ const DECK_REFILL  = 1
 const DECK_SHUFFLE = 2
 
 const SHUFFLE_DURATION = 10
+const PICK_ORDER_DURATION = 120
+const RESULTS_DURATION = 60
 const TILE_SCORE_DURATION = 30
 
+const MESSAGE_DURATION = 180
+
+const MODE_CLASSIC = 0
+const MODE_OLD_SCHOOL = 1
+const MODE_AUTOMATIC = 2
+const MODE_XRAY = 3
+
+var gameModeNames = [...]string {
+    "classic",
+    "oldschool",
+    "automatic",
+    "xray",
+}
+
+// indexed by engine.CHALLENGE_*
+var challengeRuleNames = [...]string {
+    "single",
+    "double",
+    "points",
+}
+
+const CHALLENGE_DURATION = 300
+
+const CLOCK_NONE = 0
+const CLOCK_TURN = 1
+const CLOCK_GAME = 2
+const CLOCK_FISCHER = 3
+
+var clockModeNames = [...]string {
+    "none",
+    "turn",
+    "game",
+    "fischer",
+}
+
+const BUTTON_PASS = 0
+const BUTTON_EXCHANGE = 1
+const BUTTON_PLAY = 2
+const BUTTON_RECALL = 3
+
+// the menu before a game starts, and the one that Escape opens during a turn
+const MENU_NEW_GAME = 0
+const MENU_LOAD_GAME = 1
+const MENU_RESUME_GAME = 2 // only there if the last game was never finished
+const MENU_WATCH_REPLAY = 3 // only there if a game record was given in the config
+
+var startMenuLabels = [...]string {
+    "New game",
+    "Load game",
+    "Resume unfinished game",
+    "Watch replay",
+}
+
+const PAUSE_RESUME = 0
+const PAUSE_SAVE_GAME = 1
+const PAUSE_LOAD_GAME = 2
+
+var pauseMenuLabels = [...]string {
+    "Resume",
+    "Save game",
+    "Load game",
+}
+
 const PLAYER_INACTIVE = 0
 const PLAYER_REAL = 1
 const PLAYER_CPU_EASY = 2
 const PLAYER_CPU_HARD = 3
 
-var boardTileTypeLookup = [...]int32 {
-    2, 0, 0, 3, 0, 0, 0, 2,
-    0, 1, 0, 0, 0, 4, 0, 0,
-    0, 0, 1, 0, 0, 0, 3, 0,
-    3, 0, 0, 1, 0, 0, 0, 3,
-    0, 0, 0, 0, 1, 0, 0, 0,
-    0, 4, 0, 0, 0, 4, 0, 0,
-    0, 0, 3, 0, 0, 0, 3, 0,
-}
-
-var tiles = [...]Tile {
-	{'A', 1, 9},
-	{'B', 3, 2},
-	{'C', 3, 2},
-	{'D', 2, 4},
-	{'E', 1, 12},
-	{'F', 4, 2},
-	{'G', 2, 3},
-	{'H', 4, 2},
-	{'I', 1, 9},
-	{'J', 8, 1},
-	{'K', 5, 1},
-	{'L', 1, 4},
-	{'M', 3, 2},
-	{'N', 1, 6},
-	{'O', 1, 8},
-	{'P', 3, 2},
-	{'Q', 10, 1},
-	{'R', 1, 6},
-	{'S', 1, 4},
-	{'T', 1, 6},
-	{'U', 1, 4},
-	{'V', 4, 2},
-	{'W', 4, 2},
-	{'X', 8, 1},
-	{'Y', 4, 2},
-	{'Z', 10, 1},
-	{' ', 0, 2},
-}
-
-func getTileType(x, y int32) int32 {
-    if x < 0 || y < 0 || x >= 15 || y >= 15 {
-        return NORMAL
-    }
-    if x == 7 && y == 7 {
-        return DOUBLE_WORD
-    }
-
-    if x >= 7 && y >= 8 {
-        x = 14 - x
-        y = 14 - y
-    } else if x >= 8 && y <= 7 {
-        temp := x
-        x = y
-        y = 14 - temp
-    } else if x <= 7 && y >= 7 {
-        temp := x
-        x = 14 - y
-        y = temp
-    }
-
-    return boardTileTypeLookup[x + 8 * y]
-}
-
-func getLetterScores() (scores []int32) {
-    scores = make([]int32, 27)
-    for i := 0; i < 27; i++ {
-        scores[i] = tiles[i].points
-    }
-    return scores
-}
-
-func generateTileBag() ([]byte, []int32) {
-	var chars []byte
-	idx := 0
-	for i := 0; i < len(tiles); i++ {
-		n := int(tiles[i].count)
-		if idx + n > cap(chars) {
-			chars = append(make([]byte, 0, max(idx + n, cap(chars))), chars...)
-		}
-		chars = chars[:idx+n]
-
-		for j := 0; j < n; j++ {
-			chars[idx+j] = byte(tiles[i].letter & 0xff)
-		}
-		idx += n
-	}
-
-	bag := make([]int32, idx)
-	for i := 0; i < idx; i++ {
-		bag[i] = int32(i)
-	}
-
-	return chars, bag
+func findName(names []string, name string) int32 {
+    for i := 0; i < len(names); i++ {
+        if names[i] == name {
+            return int32(i)
+        }
+    }
+    return 0
 }
 
-func (game *Game) takeTileFromBag() int8 {
-	if len(game.bagMap) == 0 {
-		return 0
-	}
+func getGameMode(name string) int32 {
+    return findName(gameModeNames[:], name)
+}
 
-	idx := int(game.getRandom(int64(len(game.bagMap))))
-	selected := game.bagMap[idx]
-	game.bagMap[idx] = game.bagMap[len(game.bagMap)-1]
-	game.bagMap = game.bagMap[:len(game.bagMap)-1]
+func getChallengeRule(name string) int32 {
+    return findName(challengeRuleNames[:], name)
+}
 
-	ch := game.bagChars[selected]
-	if ch == ' ' {
-		return 27
-	}
-	return int8(ch) - 0x40
+func getClockMode(name string) int32 {
+    return findName(clockModeNames[:], name)
 }
 
-func (game *Game) updateShuffleBuffer() {
-    for i := 0; i < 7; i++ {
-        game.shuffleBuf[7+i] = int8(i)
-    }
-    for i := 0; i < 7; i++ {
-        idx := int(game.getRandom(int64(7-i)))
-        game.shuffleBuf[i] = game.shuffleBuf[7+idx]
-        game.shuffleBuf[7+idx] = game.shuffleBuf[13-i]
-    }
-    for i := 0; i < 7; i++ {
-        game.shuffleBuf[7 + game.shuffleBuf[i]] = int8(i)
+func (game *Game) setTileSet(set []engine.Tile) {
+    game.keyMap = make(map[rune]int16)
+    for i := 0; i < len(set) - 1; i++ {
+        game.keyMap[set[i].Key] = int16(i + 1)
     }
 }
 
-func (game *Game) getBoardTile(index int) Tile {
-    if index < 0 || index >= 15 * 15 || game.boardTiles[index] == 0 {
-        return Tile{}
-    }
-    return tiles[game.boardTiles[index] - 1]
+// returns 0 if the key isn't mapped to a letter
+func (game *Game) getTileForKey(char rune) int16 {
+    return game.keyMap[unicode.ToUpper(char)]
 }
 
-func (game *Game) init(wordsList []string, timestamp int64) {
-	game.wordsList = wordsList
-	game.startupTimestamp = timestamp
-	game.boardTiles = make([]int8, 15 * 15)
+// slot 0 is the leftmost tile on the rack. slots past the end of a smaller rack are always empty
+func getRackTile(deck uint64, slot int) int16 {
+	return int16((deck >> ((engine.MAX_RACK_SIZE-slot-1)*RACK_SLOT_BITS)) & 0x7f)
+}
 
-	game.wordMap = make(map[string]int)
-	for i := 0; i < len(game.wordsList); i++ {
-		game.wordMap[game.wordsList[i]] = i
-	}
+func setRackTile(deck uint64, slot int, tile int16) uint64 {
+	shift := (engine.MAX_RACK_SIZE-slot-1)*RACK_SLOT_BITS
+	return (deck & ^(uint64(0x7f) << shift)) | (uint64(tile & 0x7f) << shift)
+}
 
-    game.scoreDisplayStrings = make([]string, max(BONUS + 4, 51))
-    for i := 0; i <= 10; i++ {
-        game.scoreDisplayStrings[i] = "+" + strconv.Itoa(i)
+func (game *Game) updateShuffleBuffer() {
+    n := game.menu.rackSize
+    for i := 0; i < n; i++ {
+        game.shuffleBuf[n+i] = int8(i)
     }
-    for i := 2; i <= 3; i++ {
-        game.scoreDisplayStrings[BONUS + i] = "x" + strconv.Itoa(i)
+    for i := 0; i < n; i++ {
+        idx := int(game.getRandom(int64(n-i)))
+        game.shuffleBuf[i] = game.shuffleBuf[n+idx]
+        game.shuffleBuf[n+idx] = game.shuffleBuf[2*n-1-i]
     }
-    game.scoreDisplayStrings[50] = "+50"
+    for i := 0; i < n; i++ {
+        game.shuffleBuf[n + int(game.shuffleBuf[i])] = int8(i)
+    }
+}
+
+func (game *Game) init(wordsList []string, tileSet []engine.Tile, timestamp int64) {
+	game.rules.Init(wordsList, tileSet)
+	game.setTileSet(tileSet)
+	game.startupTimestamp = timestamp
 }
 
 func (game *Game) getRandom(endExclusive int64) int64 {
@@ -274,38 +302,262 @@ func (game *Game) getRandom(endExclusive int64) int64 {
 	return value
 }
 
+// the menu settings only reach the rules when a game starts
+func (game *Game) applySettings() {
+    game.rules.RackSize = game.menu.rackSize
+    game.rules.ScorelessTurnLimit = game.menu.scorelessTurnLimit
+    game.rules.ChallengeRule = game.menu.challengeRule
+    game.rules.ChallengePoints = int32(game.menu.challengePoints)
+    game.rules.ShouldValidate = game.menu.shouldValidateEveryWord
+}
+
 func (game *Game) start() {
-    game.bagChars, game.bagMap = generateTileBag()
-	for i := 0; i < 15 * 15; i++ {
-		game.boardTiles[i] = 0
-	}
+    game.isReplay = false
+    game.applySettings()
+    game.rules.Seed = uint64(game.menu.seed)
+    if game.menu.seed == 0 {
+        game.rules.Seed = uint64(game.getRandom(0))
+    }
+    game.rules.Reset()
+    game.isAutosaveCurrent = false
+    game.isAutosaveFailing = false
+
 	for i := 0; i < 4; i++ {
+	    game.rules.IsActive[i] = game.players[i].kind != PLAYER_INACTIVE
 	    game.players[i].totalScore = 0
+	    game.players[i].clockFrames = int32(secsToFrames(game.menu.timeLimitSecs))
+	    game.players[i].overtimeFrames = 0
+	}
+	game.clearTurns()
+
+    game.orderContenders = 0
+    for i := 0; i < 4; i++ {
+        game.orderTiles[i] = 0
+        if game.players[i].kind != PLAYER_INACTIVE {
+            game.orderContenders |= 1 << i
+        }
+    }
+    game.drawOrderTiles()
+
+    game.state.prev = 0
+    game.state.cur = PICK_ORDER
+}
+
+// forgets everything about the turn in progress, ready to start a game or carry on a saved one
+func (game *Game) clearTurns() {
+	for i := 0; i < 4; i++ {
 	    game.players[i].turnScore = 0
-	    for j := 0; j < 7; j++ {
+	    for j := 0; j < engine.MAX_RACK_SIZE; j++ {
 		    game.players[i].turnLetters[j] = 0
 		    game.players[i].turnPositions[j] = 0
 	    }
 	    game.players[i].nTilesHeld = 0
+	    game.players[i].nTilesStaged = 0
+	    for j := 0; j < engine.MAX_RACK_SIZE; j++ {
+		    game.players[i].stagedLetters[j] = 0
+		    game.players[i].stagedPositions[j] = 0
+	    }
 	    game.players[i].turnOffsetsBits.reset()
 	    game.players[i].deckTilesBits.reset()
 	}
 
-    game.state.prev = 0
-    game.state.cur = PLAYER_TURN
+    game.isResultsDismissed = false
+    game.isExchanging = false
+    game.exchangeSlots = 0
+    game.isChallengeable = false
+    game.isDragging = false
+    game.isChoosingBlank = false
+    game.isMenuOpen = false
+    game.invalidWords = game.invalidWords[0:0]
+}
+
+func (game *Game) dealTiles(first int32) {
+    game.rules.Deal(first)
+    for i := 0; i < 4; i++ {
+        if game.players[i].kind != PLAYER_INACTIVE {
+            game.players[i].deckTilesBits.cur = makeRack(game.rules.Racks[i])
+        }
+    }
+}
+
+func makeRack(tiles []int16) (deck uint64) {
+    for j := 0; j < len(tiles) && j < engine.MAX_RACK_SIZE; j++ {
+        deck = setRackTile(deck, j, tiles[j])
+    }
+    return deck
+}
+
+func (game *Game) drawOrderTiles() {
+    for i := 0; i < 4; i++ {
+        if (game.orderContenders & (1 << i)) != 0 {
+            game.orderTiles[i] = game.rules.TakeTile()
+        }
+    }
     game.state.animPos = 0
-    game.state.animLen = 80
+    game.state.animLen = PICK_ORDER_DURATION
+}
 
+// the tile closest to the start of the alphabet goes first, and a blank beats everything
+func (game *Game) getOrderRank(tile int16) int32 {
+    if tile == game.rules.BlankTile {
+        return 0
+    }
+    return int32(tile)
+}
+
+// returns the players that drew the best tile this round as a bitmask
+func (game *Game) getOrderLeaders() (leaders int32) {
+    bestRank := int32(engine.MAX_LETTERS + 2)
     for i := 0; i < 4; i++ {
+        if (game.orderContenders & (1 << i)) == 0 {
+            continue
+        }
+        rank := game.getOrderRank(game.orderTiles[i])
+        if rank < bestRank {
+            bestRank = rank
+            leaders = 0
+        }
+        if rank == bestRank {
+            leaders |= 1 << i
+        }
+    }
+    return leaders
+}
+
+func (r *Rect) contains(x, y int32) bool {
+    return x >= r.x && y >= r.y && x < r.x + r.w && y < r.y + r.h
+}
+
+func (game *Game) getScoreRect(playerIdx int) (r Rect) {
+    boardLen := game.tileSize * int32(game.rules.Layout.Size)
+    xBoardOff := (game.wndWidth - boardLen) / 2
+    yBoardOff := (game.wndHeight - boardLen - 2 * game.tileSize) / 2
+
+    textSize := min(game.wndWidth, game.wndHeight) / 32
+    r.w = textSize * 4
+    r.h = (textSize * 5) / 4
+    r.x = xBoardOff - r.w - textSize
+    r.y = yBoardOff + textSize
+
+    for i := 0; i < playerIdx; i++ {
         if game.players[i].kind == PLAYER_INACTIVE {
             continue
         }
-        for j := 0; j < 7; j++ {
-            tile := game.takeTileFromBag()
-            game.players[i].deckTilesBits.cur <<= 8
-            game.players[i].deckTilesBits.cur |= uint64(tile & 0x7f)
+        r.y += r.h + (textSize / 2)
+        if game.menu.clockMode != CLOCK_NONE {
+            r.y += textSize + (textSize / 2)
         }
     }
+    return r
+}
+
+func getTileTextureSizes(tileSize int32) (small, large int32) {
+    small = min(tileSize - 1, int32(float64(tileSize) * 0.95))
+    large = int32(1.4 * float64(small))
+    return small, large
+}
+
+func (game *Game) getDeckLayout() (layout DeckLayout) {
+    _, largeTileSize := getTileTextureSizes(game.tileSize)
+    layout.tileSize = float32(largeTileSize)
+    layout.tilePad = layout.tileSize * 0.25
+    nSlots := float32(game.menu.rackSize)
+    tilesSpan := int32(nSlots * layout.tileSize + (nSlots - 1.0) * layout.tilePad)
+    layout.sidePad = int32(float64(tilesSpan) * 0.05)
+
+    boardLen := game.tileSize * int32(game.rules.Layout.Size)
+    yBoardOff := (game.wndHeight - boardLen - 2 * game.tileSize) / 2
+    leftoverH := game.wndHeight - yBoardOff - boardLen
+
+    layout.rect.w = 2 * layout.sidePad + tilesSpan
+    layout.rect.h = int32(layout.tileSize + layout.tilePad)
+    layout.rect.x = game.wndWidth - ((game.wndWidth + layout.rect.w) / 2)
+    layout.rect.y = yBoardOff + boardLen + (leftoverH / 2) - (layout.rect.h / 2)
+    return layout
+}
+
+// returns -1 if the point isn't over a tile slot
+func (game *Game) getDeckSlotAt(x, y int32) int {
+    layout := game.getDeckLayout()
+    if !layout.rect.contains(x, y) {
+        return -1
+    }
+    slotX := float32(x - layout.rect.x - layout.sidePad)
+    slot := int(slotX / (layout.tileSize + layout.tilePad))
+    if slotX < 0 || slot >= game.menu.rackSize || slotX - float32(slot) * (layout.tileSize + layout.tilePad) > layout.tileSize {
+        return -1
+    }
+    return slot
+}
+
+// the chooser is a 9x3 grid of letters over the middle of the board. passing -1 gives the whole panel
+func (game *Game) getBlankChooserRect(idx int) (r Rect) {
+    small, _ := getTileTextureSizes(game.tileSize)
+    cell := game.tileSize + game.tileSize / 4
+    textSize := min(game.wndWidth, game.wndHeight) / 40
+    pad := game.tileSize / 2
+
+    rows := int32(game.rules.GetLetterCount() + 8) / 9
+    panelW := 9 * cell + 2 * pad
+    panelH := rows * cell + 2 * pad + textSize * 2
+    boardLen := game.tileSize * int32(game.rules.Layout.Size)
+    yBoardOff := (game.wndHeight - boardLen - 2 * game.tileSize) / 2
+    panelX := (game.wndWidth - panelW) / 2
+    panelY := yBoardOff + (boardLen - panelH) / 2
+    if idx < 0 {
+        return Rect{panelX, panelY, panelW, panelH}
+    }
+
+    r.w = small
+    r.h = small
+    r.x = panelX + pad + int32(idx % 9) * cell + (cell - small) / 2
+    r.y = panelY + pad + textSize * 2 + int32(idx / 9) * cell + (cell - small) / 2
+    return r
+}
+
+// the handle sits just above the right end of the deck
+func (game *Game) getRotateHandleRect() (r Rect) {
+    layout := game.getDeckLayout()
+    r.w = layout.rect.h / 2
+    r.h = r.w
+    r.x = layout.rect.x + layout.rect.w - r.w
+    r.y = layout.rect.y - r.h - layout.rect.h / 8
+    return r
+}
+
+// buttons are stacked in pairs beside the deck
+func (game *Game) getButtonRect(idx int) (r Rect) {
+    layout := game.getDeckLayout()
+    gap := layout.rect.h / 8
+    r.w = (layout.rect.h * 5) / 4
+    r.h = (layout.rect.h - gap) / 2
+    r.x = layout.rect.x + layout.rect.w + gap
+    r.y = layout.rect.y + int32(idx % 2) * (r.h + gap)
+    if idx >= 2 {
+        r.x = layout.rect.x - gap - r.w
+    }
+    return r
+}
+
+// the entries are stacked in the middle of the window
+func (game *Game) getMenuEntryRect(idx, nEntries int) (r Rect) {
+    r.h = min(game.wndWidth, game.wndHeight) / 12
+    r.w = r.h * 5
+    gap := r.h / 4
+    r.x = (game.wndWidth - r.w) / 2
+    r.y = (game.wndHeight - int32(nEntries) * (r.h + gap) + gap) / 2 + int32(idx) * (r.h + gap)
+    return r
+}
+
+// returns -1 if there's no entry at that point
+func (game *Game) getMenuEntryAt(x, y int32, nEntries int) int {
+    for i := 0; i < nEntries; i++ {
+        r := game.getMenuEntryRect(i, nEntries)
+        if r.contains(x, y) {
+            return i
+        }
+    }
+    return -1
 }
 
 func (game *Game) updateCursor(inputs *Inputs) {
@@ -334,19 +586,110 @@ func (game *Game) updateCursor(inputs *Inputs) {
     }
 }
 
+func (game *Game) showMessage(msg string) {
+    game.message = msg
+    game.messageTimer = MESSAGE_DURATION
+}
+
 func (game *Game) simulate(inputs *Inputs) {
     game.state.step()
 
+    if game.messageTimer > 0 {
+        game.messageTimer--
+    }
+
     player := int32(game.state.cur) & 3
     mode := int32(game.state.cur) & ^3
 
-    if mode == PLAYER_TURN {
+    // the clock stops while the menu is open
+    if game.isMenuOpen {
+        game.simulatePauseMenu(inputs)
+        return
+    }
+
+    if mode == PLAYER_TURN && game.tickClock(player) {
+        game.passTurn(inputs, player, "Player " + strconv.Itoa(int(player) + 1) + " ran out of time")
+        return
+    }
+
+    if mode == PICK_ORDER {
+        game.simulatePickOrder()
+    } else if mode == PLAYER_TURN {
         game.simulatePlayerTurn(inputs, player)
     } else if mode == SCORING_TURN {
         game.simulateScoringTurn(inputs, player)
+    } else if mode == GAME_OVER {
+        game.simulateGameOver(inputs)
+    } else if mode == REPLAY {
+        game.simulateReplay(inputs)
     }
 }
 
+func (game *Game) simulatePickOrder() {
+    if game.state.animLen > 0 {
+        return
+    }
+
+    leaders := game.getOrderLeaders()
+    for i := 0; i < 4; i++ {
+        if (game.orderContenders & (1 << i)) != 0 {
+            game.rules.PutTile(game.orderTiles[i])
+        }
+    }
+    game.orderContenders = leaders
+
+    // ties are broken by drawing again
+    if (leaders & (leaders - 1)) != 0 {
+        game.drawOrderTiles()
+        return
+    }
+
+    first := int32(0)
+    for first < 3 && (leaders & (1 << first)) == 0 {
+        first++
+    }
+
+    game.dealTiles(first)
+    game.beginTurn(first)
+}
+
+func (game *Game) beginTurn(playerIdx int32) {
+    if game.menu.clockMode == CLOCK_TURN {
+        game.players[playerIdx].clockFrames = int32(secsToFrames(game.menu.timeLimitSecs))
+    }
+
+    game.state.cur = uint64(PLAYER_TURN | playerIdx)
+    game.state.animPos = 0
+    game.state.animLen = 80
+}
+
+// returns true if the player has just run out of time and should forfeit their turn
+func (game *Game) tickClock(playerIdx int32) bool {
+    if game.menu.clockMode == CLOCK_NONE {
+        return false
+    }
+
+    p := &game.players[playerIdx]
+    if p.clockFrames > 0 {
+        p.clockFrames--
+        return p.clockFrames == 0 && game.menu.clockMode != CLOCK_GAME
+    }
+    if game.menu.clockMode == CLOCK_GAME {
+        p.overtimeFrames++
+    }
+    return false
+}
+
+// each minute or part of a minute spent over the time limit costs a fixed number of points
+func (game *Game) getOvertimePenalty(p *Player) int32 {
+    if p.overtimeFrames == 0 {
+        return 0
+    }
+    minuteFrames := int32(secsToFrames(60))
+    minutes := (p.overtimeFrames + minuteFrames - 1) / minuteFrames
+    return minutes * int32(game.menu.overtimePenalty)
+}
+
 func (game *Game) simulatePlayerTurn(inputs *Inputs, playerIdx int32) {
     game.shuffleTimer--
     if game.shuffleTimer > 0 {
@@ -356,75 +699,134 @@ func (game *Game) simulatePlayerTurn(inputs *Inputs, playerIdx int32) {
 
     p := &game.players[playerIdx]
 
+    clickedButton := -1
+    if (inputs.mouseButtons[0] & 1) == 1 {
+        for i := 0; i < 4; i++ {
+            r := game.getButtonRect(i)
+            if r.contains(inputs.cursorX, inputs.cursorY) {
+                clickedButton = i
+                inputs.mouseButtons[0] &= ^1
+                break
+            }
+        }
+    }
+    for _, code := range inputs.pressedKeys {
+        if code == KEY_PASS {
+            clickedButton = BUTTON_PASS
+        } else if code == KEY_EXCHANGE {
+            clickedButton = BUTTON_EXCHANGE
+        }
+    }
+
+    if clickedButton == BUTTON_PASS {
+        game.passTurn(inputs, playerIdx, "Player " + strconv.Itoa(int(playerIdx) + 1) + " passed")
+        return
+    }
+    if clickedButton == BUTTON_EXCHANGE && !game.isExchanging {
+        game.isExchanging = true
+        game.exchangeSlots = 0
+        game.recallTiles(p)
+        return
+    }
+    if game.isExchanging {
+        game.simulateExchange(inputs, playerIdx, clickedButton == BUTTON_EXCHANGE)
+        return
+    }
+
+    if game.isChoosingBlank {
+        game.simulateBlankChooser(inputs, p)
+        return
+    }
+
+    isHandleClicked := false
+    if (inputs.mouseButtons[0] & 1) == 1 {
+        r := game.getRotateHandleRect()
+        slot := game.getDeckSlotAt(inputs.cursorX, inputs.cursorY)
+        if r.contains(inputs.cursorX, inputs.cursorY) {
+            isHandleClicked = true
+            inputs.mouseButtons[0] &= ^1
+        } else if slot >= 0 && getRackTile(p.deckTilesBits.cur, slot) == game.rules.BlankTile {
+            game.pickUpRackTile(p, slot, game.rules.BlankTile)
+            game.isChoosingBlank = true
+            return
+        } else if slot >= 0 && getRackTile(p.deckTilesBits.cur, slot) != 0 {
+            game.pickUpRackTile(p, slot, getRackTile(p.deckTilesBits.cur, slot))
+            game.startDrag(inputs)
+            inputs.mouseButtons[0] &= ^1
+        }
+    }
+
     for _, char := range inputs.pressedChars {
-        idx := int8(0)
-        if char >= 'a' && char <= 'z' {
-            idx = int8(char - 0x60)
-        } else if char >= 'A' && char <= 'Z' {
-            idx = int8(char - 0x40)
+        if char == '?' {
+            for j := 0; j < game.menu.rackSize; j++ {
+                if getRackTile(p.deckTilesBits.cur, j) == game.rules.BlankTile {
+                    game.pickUpRackTile(p, j, game.rules.BlankTile)
+                    game.isChoosingBlank = true
+                    return
+                }
+            }
+            continue
         }
+
+        idx := game.getTileForKey(char)
         if idx == 0 {
             continue
         }
 
         deckTiles := p.deckTilesBits.cur
         pos := -1
-        for j := 0; j < 7; j++ {
-            if ((deckTiles >> ((7-j-1)*8)) & 0x7f) == uint64(idx & 0x7f) {
+        for j := 0; j < game.menu.rackSize; j++ {
+            if getRackTile(deckTiles, j) == idx {
                 pos = j
                 break
             }
         }
 
-        isBlank := int8(0)
+        isBlank := int16(0)
         if pos < 0 {
-            for j := 0; j < 7; j++ {
-                if ((deckTiles >> ((7-j-1)*8)) & 0x7f) == uint64(27) {
+            for j := 0; j < game.menu.rackSize; j++ {
+                if getRackTile(deckTiles, j) == game.rules.BlankTile {
                     pos = j
-                    isBlank = 0x20
+                    isBlank = engine.BLANK_FLAG
                     break
                 }
             }
         }
 
         if pos >= 0 {
-            if pos == 0 {
-                p.deckTilesBits.cur &= 0xffFFffFFffFF
-            } else if pos == 6 {
-                p.deckTilesBits.cur >>= 8
-            } else {
-                topMask := ((uint64(1) << (8*pos)) - 1) << ((7-pos)*8)
-                bottomMask := (uint64(1) << ((6-pos)*8)) - 1
-                p.deckTilesBits.cur = (p.deckTilesBits.cur & topMask) >> 8 | (p.deckTilesBits.cur & bottomMask)
-            }
-            p.turnLetters[p.nTilesHeld] = idx | isBlank
-            p.turnPositions[p.nTilesHeld] = 0
-            p.nTilesHeld++
+            game.pickUpRackTile(p, pos, idx | isBlank)
         }
     }
 
     shouldRotate := false
-    shouldPlace := false
+    shouldStage := false
+    shouldPlace := clickedButton == BUTTON_PLAY
     shouldShuffle := false
 
+    if clickedButton == BUTTON_RECALL {
+        game.recallTiles(p)
+    }
+
     for _, code := range inputs.pressedKeys {
         if code == KEY_BACKSPACE {
-            if p.nTilesHeld <= 0 {
-                p.nTilesHeld = 0
-                continue
+            if p.nTilesHeld > 0 {
+                p.nTilesHeld--
+                game.returnTileToRack(p, p.turnLetters[p.nTilesHeld])
+                p.turnLetters[p.nTilesHeld] = 0
+            } else if p.nTilesStaged > 0 {
+                game.invalidWords = game.invalidWords[0:0]
+                p.nTilesStaged--
+                game.returnTileToRack(p, p.stagedLetters[p.nTilesStaged])
+                p.stagedLetters[p.nTilesStaged] = 0
+                p.stagedPositions[p.nTilesStaged] = 0
             }
-            deckTiles := p.deckTilesBits.cur
-            for j := 0; j < 7; j++ {
-                if ((deckTiles >> (j*8)) & 0x7f) == 0 {
-                    p.nTilesHeld--
-                    tile := uint64(p.turnLetters[p.nTilesHeld])
-                    if (tile & 0x20) != 0 {
-                        tile = 27
-                    }
-                    p.deckTilesBits.cur |= tile << (j*8)
-                    break
-                }
+        } else if code == KEY_ESCAPE {
+            // with nothing to take back, Escape opens the menu
+            if p.nTilesHeld == 0 && p.nTilesStaged == 0 {
+                game.isMenuOpen = true
+                return
             }
+            game.recallTiles(p)
         } else if code == KEY_LSHIFT || code == KEY_RSHIFT {
             shouldRotate = p.turnState.cur == ROTA_VERT
         } else if code == KEY_LCTRL || code == KEY_RCTRL {
@@ -436,11 +838,21 @@ func (game *Game) simulatePlayerTurn(inputs *Inputs, playerIdx int32) {
         }
     }
 
-    if (inputs.mouseButtons[1] & 1) == 1 {
+    if (inputs.mouseButtons[1] & 1) == 1 || isHandleClicked {
         shouldRotate = true
     }
     if (inputs.mouseButtons[0] & 1) == 1 {
-        shouldPlace = true
+        shouldStage = true
+    }
+
+    // letting go of a dragged tile drops it where it is, or back on the rack if it isn't over the board.
+    // a press and release without moving is a click, so the tiles stay in hand
+    isDropped := false
+    if game.isDragging && (inputs.mouseButtons[0] & 4) != 0 {
+        game.isDragging = false
+        dx := inputs.cursorX - game.dragStartX
+        dy := inputs.cursorY - game.dragStartY
+        isDropped = dx * dx + dy * dy >= 25
     }
 
     if shouldRotate {
@@ -464,19 +876,39 @@ func (game *Game) simulatePlayerTurn(inputs *Inputs, playerIdx int32) {
 
     didPlace := false
 
-    nHeld := int(p.nTilesHeld)
-    if nHeld > 0 {
-        tileSize := int(game.tileSize)
-        boardLen := tileSize * 15
-        xBoardOff := (int(game.wndWidth) - boardLen) / 2
-        yBoardOff := (int(game.wndHeight) - boardLen - 2 * tileSize) / 2
+    game.previewWords = game.previewWords[0:0]
+
+    size := game.rules.Layout.Size
+    tileSize := int(game.tileSize)
+    boardLen := tileSize * size
+    xBoardOff := (int(game.wndWidth) - boardLen) / 2
+    yBoardOff := (int(game.wndHeight) - boardLen - 2 * tileSize) / 2
+
+    boardCurX := int(game.turnCursorX) - xBoardOff
+    boardCurY := int(game.turnCursorY) - yBoardOff
+    col := boardCurX / tileSize
+    row := boardCurY / tileSize
+    isCursorOnBoard := boardCurX >= 0 && boardCurY >= 0 && col >= 0 && col < size && row >= 0 && row < size
+
+    // clicking a tile that was put down this turn picks it back up
+    if shouldStage && p.nTilesHeld == 0 && isCursorOnBoard {
+        if game.pickUpStagedTile(p, col + size * row) {
+            game.startDrag(inputs)
+        }
+        shouldStage = false
+    }
 
-        boardCurX := int(game.turnCursorX) - xBoardOff
-        boardCurY := int(game.turnCursorY) - yBoardOff
-        col := boardCurX / tileSize
-        row := boardCurY / tileSize
+    if isDropped {
+        if isCursorOnBoard {
+            shouldStage = true
+        } else {
+            game.returnHeldTiles(p)
+        }
+    }
 
-        if boardCurX >= 0 && boardCurY >= 0 && col >= 0 && col < 15 && row >= 0 && row < 15 {
+    nHeld := int(p.nTilesHeld)
+    if nHeld > 0 {
+        if isCursorOnBoard {
             xInc := 1
             yInc := 0
             if p.turnState.cur == ROTA_VERT {
@@ -486,6 +918,7 @@ func (game *Game) simulatePlayerTurn(inputs *Inputs, playerIdx int32) {
 
             offsetBits := uint64(0)
             totalOffset := 0
+            isOffBoard := false
             x := col
             y := row
             for i := 0; i < nHeld; i++ {
@@ -494,10 +927,10 @@ func (game *Game) simulatePlayerTurn(inputs *Inputs, playerIdx int32) {
                 for true {
                     x = col + xInc * (i + totalOffset)
                     y = row + yInc * (i + totalOffset)
-                    if x >= 15 || y >= 15 {
-                        shouldPlace = false
+                    if x >= size || y >= size {
+                        isOffBoard = true
                         break
-                    } else if game.boardTiles[x + 15 * y] != 0 {
+                    } else if game.isSquareTaken(p, x + size * y) {
                         totalOffset++
                         offset++
                     } else {
@@ -509,71 +942,91 @@ func (game *Game) simulatePlayerTurn(inputs *Inputs, playerIdx int32) {
 
             p.turnOffsetsBits.cur = offsetBits
 
-            didPlace = shouldPlace
-
             offset := 0
             for i := 0; i < nHeld; i++ {
                 offset += int((offsetBits >> ((nHeld-i-1)*4)) & 0xf)
                 x = col + xInc * (i + offset)
                 y = row + yInc * (i + offset)
-                if didPlace {
-                    game.boardTiles[x + 15 * y] = p.turnLetters[i]
-                    p.turnLetters[i] = 0
+                if x >= size || y >= size {
+                    p.turnPositions[i] = 0
+                } else {
+                    p.turnPositions[i] = uint16((x + size * y) + 1) // +1 for sentinel value
                 }
-                p.turnPositions[i] = uint8((x + 15 * y) + 1) // +1 for sentinel value
+            }
+            for i := nHeld; i < engine.MAX_RACK_SIZE; i++ {
+                p.turnPositions[i] = 0
+            }
+
+            if (shouldStage || shouldPlace) && !isOffBoard {
+                game.stageHeldTiles(p)
+            } else if shouldStage {
+                game.showMessage(engine.PlacementErrorStrings[engine.PLACE_OFF_BOARD])
+            }
+        } else {
+            for i := 0; i < engine.MAX_RACK_SIZE; i++ {
+                p.turnPositions[i] = 0
             }
         }
     }
 
+    if game.menu.gameMode == MODE_XRAY {
+        tiles, cells := game.collectTurnTiles(p)
+        game.previewWords = game.rules.FindWords(tiles, cells)
+    }
+
+    var res engine.Result
+    if shouldPlace && p.nTilesHeld == 0 && p.nTilesStaged > 0 {
+        tiles, cells := game.collectTurnTiles(p)
+        move := engine.Move{engine.MOVE_PLAY, playerIdx, tiles, cells}
+        var err error
+        res, err = game.rules.Apply(move)
+        if err != nil {
+            // the tiles stay where they are, so the player can move them or recall them
+            game.showMessage(err.Error())
+            if game.rules.ShouldValidate && game.rules.CheckPlacement(cells) == engine.PLACE_OK {
+                game.findInvalidWords(tiles, cells)
+            }
+        } else {
+            didPlace = true
+        }
+    }
+
     if didPlace {
+        // the staged tiles become the tiles played this turn
+        for i := 0; i < engine.MAX_RACK_SIZE; i++ {
+            p.turnLetters[i] = 0
+            p.turnPositions[i] = p.stagedPositions[i]
+            p.stagedLetters[i] = 0
+            p.stagedPositions[i] = 0
+        }
+        p.nTilesStaged = 0
+    }
+
+    if didPlace {
+        game.rackBeforeDraw = p.deckTilesBits.cur
         p.deckTilesBits.prev = p.deckTilesBits.cur
         p.deckTilesBits.animPos = 0
         p.deckTilesBits.animLen = 60
 
-        for i := 0; i < 7; i++ {
-            shift := (7-i-1)*8
-            if ((p.deckTilesBits.cur >> shift) & 0x7f) == 0 {
-                tile := game.takeTileFromBag()
-                if tile == 0 {
-                    break
-                }
-                p.deckTilesBits.cur |= uint64(tile & 0x7f) << shift
+        n := 0
+        for i := 0; i < game.menu.rackSize && n < len(res.Drawn); i++ {
+            if getRackTile(p.deckTilesBits.cur, i) == 0 {
+                p.deckTilesBits.cur = setRackTile(p.deckTilesBits.cur, i, res.Drawn[n])
+                n++
             }
         }
         p.nTilesHeld = 0
         p.turnOffsetsBits.cur = 0
 
-        score := game.findNewWords(p)
-        allWordsAreValid := true
-
-        if game.menu.shouldValidateEveryWord {
-            for i := 0; i < len(game.scoringWords); i++ {
-                _, exists := game.wordMap[game.scoringWords[i]]
-                if !exists {
-                    // TODO: save information about this word, and the other new invalid words, to display that they're not valid
-                    allWordsAreValid = false
-                    break
-                }
-            }
-        }
-
-        if allWordsAreValid {
-            p.turnScore = int32(score)
-            game.state.animPos = 0
-            game.state.animLen = max(p.deckTilesBits.animLen, int32(len(game.scoringCommands) * TILE_SCORE_DURATION))
-            game.state.cur = uint64(SCORING_TURN | (playerIdx & 3))
-            game.simulateScoringTurn(inputs, playerIdx)
-        } else {
-            game.scoringWords = game.scoringWords[0:0]
-            game.scoringCommands = game.scoringCommands[0:0]
-        }
+        game.isChallengeable = game.menu.gameMode == MODE_OLD_SCHOOL
+        game.beginScoring(inputs, playerIdx, res)
     } else if shouldShuffle {
         game.updateShuffleBuffer()
         oldDeck := p.deckTilesBits.cur
         newDeck := uint64(0)
-        for i := 0; i < 7; i++ {
-            n := (oldDeck >> ((7-i-1) * 8)) & 0x7f
-            newDeck |= n << (game.shuffleBuf[i] * 8)
+        last := game.menu.rackSize - 1
+        for i := 0; i <= last; i++ {
+            newDeck = setRackTile(newDeck, last - int(game.shuffleBuf[i]), getRackTile(oldDeck, i))
         }
         p.deckTilesBits.prev = oldDeck
         p.deckTilesBits.cur = newDeck
@@ -587,174 +1040,573 @@ func (game *Game) simulatePlayerTurn(inputs *Inputs, playerIdx int32) {
     p.turnOffsetsBits.step()
 }
 
-func (game *Game) simulateScoringTurn(inputs *Inputs, playerIdx int32) {
-    p := &game.players[playerIdx]
-
-    p.deckTilesBits.step()
-    if game.state.animLen == 0 && p.deckTilesBits.animLen == 0 {
-        p.totalScore += p.turnScore
-        p.turnScore = 0
-
-        for i := 0; i < 7; i++ {
-            p.turnLetters[i] = 0
-            p.turnPositions[i] = 0
+// empty slots collect on the left as tiles are picked up, so returned tiles go in the rightmost gap
+func (game *Game) returnTileToRack(p *Player, tile int16) {
+    if (tile & engine.BLANK_FLAG) != 0 {
+        tile = game.rules.BlankTile
+    }
+    for j := game.menu.rackSize - 1; j >= 0; j-- {
+        if getRackTile(p.deckTilesBits.cur, j) == 0 {
+            p.deckTilesBits.cur = setRackTile(p.deckTilesBits.cur, j, tile)
+            return
         }
-        game.scoringWords = game.scoringWords[0:0]
-        game.scoringCommands = game.scoringCommands[0:0]
+    }
+}
 
-        game.updateCursor(inputs)
+// the tiles to the left of the slot move over to fill the gap
+func (game *Game) pickUpRackTile(p *Player, slot int, letter int16) {
+    deck := p.deckTilesBits.cur
+    for j := slot; j > 0; j-- {
+        deck = setRackTile(deck, j, getRackTile(deck, j - 1))
+    }
+    p.deckTilesBits.cur = setRackTile(deck, 0, 0)
+    p.turnLetters[p.nTilesHeld] = letter
+    p.turnPositions[p.nTilesHeld] = 0
+    p.nTilesHeld++
+}
 
-        nextPlayer := (playerIdx + 1) % 4
-        for i := 0; i < 3; i++ {
-            if game.players[nextPlayer].kind != PLAYER_INACTIVE {
+// the blank being given a letter is always the last tile picked up
+func (game *Game) simulateBlankChooser(inputs *Inputs, p *Player) {
+    letter := int16(0)
+    for _, char := range inputs.pressedChars {
+        if game.getTileForKey(char) != 0 {
+            letter = game.getTileForKey(char)
+        }
+    }
+    if (inputs.mouseButtons[0] & 1) == 1 {
+        for i := 0; i < game.rules.GetLetterCount(); i++ {
+            r := game.getBlankChooserRect(i)
+            if r.contains(inputs.cursorX, inputs.cursorY) {
+                letter = int16(i + 1)
                 break
             }
-            nextPlayer = (nextPlayer + 1) % 4
         }
+    }
+
+    isCancelled := false
+    for _, code := range inputs.pressedKeys {
+        if code == KEY_ESCAPE || code == KEY_BACKSPACE {
+            isCancelled = true
+        }
+    }
 
-        game.state.cur = uint64(PLAYER_TURN | nextPlayer)
-        game.state.animPos = 0
-        game.state.animLen = 80
+    if isCancelled {
+        p.nTilesHeld--
+        game.returnTileToRack(p, p.turnLetters[p.nTilesHeld])
+        p.turnLetters[p.nTilesHeld] = 0
+        game.isChoosingBlank = false
+    } else if letter != 0 {
+        p.turnLetters[p.nTilesHeld - 1] = letter | engine.BLANK_FLAG
+        game.isChoosingBlank = false
     }
+    p.deckTilesBits.prev = p.deckTilesBits.cur
 }
 
-func (game *Game) findNewWords(p *Player) (totalScore int) {
-    game.scoringWords = game.scoringWords[0:0]
-    game.scoringCommands = game.scoringCommands[0:0]
-    game.activeLines = game.activeLines[0:0]
+func (game *Game) startDrag(inputs *Inputs) {
+    game.isDragging = true
+    game.dragStartX = inputs.cursorX
+    game.dragStartY = inputs.cursorY
+}
 
-    for i := 0; i < 7; i++ {
-        pos := int(p.turnPositions[i]) - 1
-        if pos < 0 {
+func (game *Game) returnHeldTiles(p *Player) {
+    for p.nTilesHeld > 0 {
+        p.nTilesHeld--
+        game.returnTileToRack(p, p.turnLetters[p.nTilesHeld])
+        p.turnLetters[p.nTilesHeld] = 0
+        p.turnPositions[p.nTilesHeld] = 0
+    }
+    p.turnOffsetsBits.cur = 0
+}
+
+// puts every held and staged tile back on the rack
+func (game *Game) recallTiles(p *Player) {
+    game.returnHeldTiles(p)
+    game.invalidWords = game.invalidWords[0:0]
+    game.isDragging = false
+    game.isChoosingBlank = false
+    for p.nTilesStaged > 0 {
+        p.nTilesStaged--
+        game.returnTileToRack(p, p.stagedLetters[p.nTilesStaged])
+        p.stagedLetters[p.nTilesStaged] = 0
+        p.stagedPositions[p.nTilesStaged] = 0
+    }
+    for i := 0; i < engine.MAX_RACK_SIZE; i++ {
+        p.turnPositions[i] = 0
+    }
+    p.turnOffsetsBits.cur = 0
+    p.deckTilesBits.prev = p.deckTilesBits.cur
+}
+
+func (game *Game) isSquareTaken(p *Player, pos int) bool {
+    if game.rules.Board[pos] != 0 {
+        return true
+    }
+    for i := 0; i < int(p.nTilesStaged); i++ {
+        if int(p.stagedPositions[i]) - 1 == pos {
+            return true
+        }
+    }
+    return false
+}
+
+// lists the tiles being held over the board and the tiles staged on it, with their board cells
+func (game *Game) collectTurnTiles(p *Player) ([]int16, []int) {
+    game.turnTiles = game.turnTiles[0:0]
+    game.turnCells = game.turnCells[0:0]
+    for i := 0; i < int(p.nTilesHeld); i++ {
+        if p.turnPositions[i] != 0 {
+            game.turnTiles = append(game.turnTiles, p.turnLetters[i])
+            game.turnCells = append(game.turnCells, int(p.turnPositions[i]) - 1)
+        }
+    }
+    for i := 0; i < int(p.nTilesStaged); i++ {
+        game.turnTiles = append(game.turnTiles, p.stagedLetters[i])
+        game.turnCells = append(game.turnCells, int(p.stagedPositions[i]) - 1)
+    }
+    return game.turnTiles, game.turnCells
+}
+
+// records each invalid word that the tiles would make, so they can be marked on the board
+func (game *Game) findInvalidWords(tiles []int16, cells []int) {
+    game.invalidWords = game.invalidWords[0:0]
+    for _, w := range game.rules.FindWords(tiles, cells) {
+        if !w.IsValid {
+            game.invalidWords = append(game.invalidWords, w)
+        }
+    }
+}
+
+// puts the held tiles down on the squares they're hovering over, without playing them yet
+func (game *Game) stageHeldTiles(p *Player) {
+    game.invalidWords = game.invalidWords[0:0]
+    for i := 0; i < int(p.nTilesHeld); i++ {
+        p.stagedLetters[p.nTilesStaged] = p.turnLetters[i]
+        p.stagedPositions[p.nTilesStaged] = p.turnPositions[i]
+        p.nTilesStaged++
+        p.turnLetters[i] = 0
+        p.turnPositions[i] = 0
+    }
+    p.nTilesHeld = 0
+    p.turnOffsetsBits.cur = 0
+}
+
+func (game *Game) pickUpStagedTile(p *Player, pos int) bool {
+    for i := 0; i < int(p.nTilesStaged); i++ {
+        if int(p.stagedPositions[i]) - 1 != pos {
             continue
         }
-        x := pos % 15
-        y := pos / 15
+        game.invalidWords = game.invalidWords[0:0]
+        p.turnLetters[p.nTilesHeld] = p.stagedLetters[i]
+        p.turnPositions[p.nTilesHeld] = 0
+        p.nTilesHeld++
+
+        p.nTilesStaged--
+        for j := i; j < int(p.nTilesStaged); j++ {
+            p.stagedLetters[j] = p.stagedLetters[j+1]
+            p.stagedPositions[j] = p.stagedPositions[j+1]
+        }
+        p.stagedLetters[p.nTilesStaged] = 0
+        p.stagedPositions[p.nTilesStaged] = 0
+        return true
+    }
+    return false
+}
 
-        for d := 0; d < 4; d++ {
-            dx := ((d & 2) - 1) * (d & 1)
-            dy := (((d+1) & 2) - 1) * ((d+1) & 1)
-            if x + dx < 0 || x + dx >= 15 || y + dy < 0 || y + dy >= 15 {
-                continue
-            }
+func (game *Game) simulateExchange(inputs *Inputs, playerIdx int32, shouldConfirm bool) {
+    p := &game.players[playerIdx]
+    deck := p.deckTilesBits.cur
 
-            pos2 := (x + dx) + 15 * (y + dy)
-            if game.boardTiles[pos2] == 0 {
-                continue
+    for _, char := range inputs.pressedChars {
+        idx := game.getTileForKey(char)
+        if char == ' ' || char == '?' {
+            idx = game.rules.BlankTile
+        }
+        if idx == 0 {
+            continue
+        }
+        for j := 0; j < game.menu.rackSize; j++ {
+            if getRackTile(deck, j) == idx && (game.exchangeSlots & (1 << j)) == 0 {
+                game.exchangeSlots |= 1 << j
+                break
             }
+        }
+    }
 
-            // look in opposite direction for both ends until there is no tile at that position or its off the board
-            xx := x - dx
-            yy := y - dy
-            for xx >= 0 && xx < 15 && yy >= 0 && yy < 15 && game.boardTiles[xx + 15 * yy] != 0 {
-                xx -= dx
-                yy -= dy
-            }
-            start := (xx + dx) + 15 * (yy + dy)
+    if (inputs.mouseButtons[0] & 1) == 1 {
+        slot := game.getDeckSlotAt(inputs.cursorX, inputs.cursorY)
+        if slot >= 0 && getRackTile(deck, slot) != 0 {
+            game.exchangeSlots ^= 1 << slot
+        }
+    }
 
-            xx = x + dx
-            yy = y + dy
-            for xx >= 0 && xx < 15 && yy >= 0 && yy < 15 && game.boardTiles[xx + 15 * yy] != 0 {
-                xx += dx
-                yy += dy
-            }
-            end := (xx - dx) + 15 * (yy - dy)
+    for _, code := range inputs.pressedKeys {
+        if code == KEY_RETURN {
+            shouldConfirm = true
+        } else if code == KEY_ESCAPE {
+            game.isExchanging = false
+            return
+        } else if code == KEY_BACKSPACE {
+            game.exchangeSlots = 0
+        }
+    }
 
-            line := uint16(min(start, end) + (15 * 15 * max(start, end)))
-            exists := false
-            for j := 0; j < len(game.activeLines); j++ {
-                if game.activeLines[j] == line {
-                    exists = true
-                    break
-                }
-            }
+    if !shouldConfirm {
+        return
+    }
+    if game.exchangeSlots == 0 {
+        game.isExchanging = false
+        return
+    }
 
-            if !exists {
-                game.activeLines = append(game.activeLines, line)
-            }
+    var returned []int16
+    for j := 0; j < game.menu.rackSize; j++ {
+        if (game.exchangeSlots & (1 << j)) != 0 {
+            returned = append(returned, getRackTile(deck, j))
+            deck = setRackTile(deck, j, 0)
         }
     }
+    res, err := game.rules.Apply(engine.Move{engine.MOVE_EXCHANGE, playerIdx, returned, nil})
+    if err != nil {
+        game.showMessage(err.Error())
+        return
+    }
 
-    totalScore = 0
+    p.deckTilesBits.prev = deck
+    p.deckTilesBits.animPos = 0
+    p.deckTilesBits.animLen = 60
 
-    for i := 0; i < len(game.activeLines); i++ {
-        start := int32(game.activeLines[i]) % (15 * 15)
-        end   := int32(game.activeLines[i]) / (15 * 15)
-        xInc := int32(1)
-        yInc := int32(0)
-        if start % 15 == end % 15 {
-            xInc, yInc = yInc, xInc
+    n := 0
+    for j := 0; j < game.menu.rackSize && n < len(res.Drawn); j++ {
+        if (game.exchangeSlots & (1 << j)) != 0 {
+            deck = setRackTile(deck, j, res.Drawn[n])
+            n++
         }
+    }
+    p.deckTilesBits.cur = deck
 
-        game.wordBuilder.Reset()
-        wordScore := 0
-        wordMultipliers := uint64(0) // the maximum number of word multipliers per line is 3, so uint64 is fine here
-        pos := start
+    game.isExchanging = false
+    game.exchangeSlots = 0
+    game.showMessage("Player " + strconv.Itoa(int(playerIdx) + 1) + " exchanged " + strconv.Itoa(len(returned)) + " tiles")
+    game.beginScoring(inputs, playerIdx, res)
+}
 
-        for pos <= end {
-            isNewTile := false
-            for j := 0; j < 7; j++ {
-                if pos == int32(p.turnPositions[j]) - 1 {
-                    isNewTile = true
-                    break
-                }
+func (game *Game) passTurn(inputs *Inputs, playerIdx int32, msg string) {
+    game.isExchanging = false
+    game.recallTiles(&game.players[playerIdx])
+    res, err := game.rules.Apply(engine.Move{engine.MOVE_PASS, playerIdx, nil, nil})
+    if err != nil {
+        msg = err.Error()
+    }
+    game.showMessage(msg)
+    game.beginScoring(inputs, playerIdx, res)
+}
+
+func (game *Game) beginScoring(inputs *Inputs, playerIdx int32, res engine.Result) {
+    p := &game.players[playerIdx]
+    p.turnScore = res.Score
+    game.skippedPlayer = res.Skipped
+    game.invalidWords = game.invalidWords[0:0]
+
+    game.scoringCommands = game.scoringCommands[0:0]
+    for _, step := range res.Steps {
+        cmd := ScoringCommand{step.Pos, "+" + strconv.Itoa(int(step.Value))}
+        if step.IsMultiplier {
+            cmd.text = "x" + strconv.Itoa(int(step.Value))
+        }
+        game.scoringCommands = append(game.scoringCommands, cmd)
+    }
+    if game.menu.clockMode == CLOCK_FISCHER {
+        p.clockFrames += int32(secsToFrames(game.menu.incrementSecs))
+    }
+
+    game.state.animPos = 0
+    game.state.animLen = max(p.deckTilesBits.animLen, int32(len(game.scoringCommands) * TILE_SCORE_DURATION))
+    if game.isChallengeable {
+        game.state.animLen = max(game.state.animLen, CHALLENGE_DURATION)
+    }
+    game.state.cur = uint64(SCORING_TURN | (playerIdx & 3))
+    game.simulateScoringTurn(inputs, playerIdx)
+}
+
+// returns -1 if nobody has challenged the play this frame
+func (game *Game) getChallenger(inputs *Inputs, playerIdx int32) int32 {
+    challenger := int32(-1)
+    for _, char := range inputs.pressedChars {
+        if char >= '1' && char <= '4' {
+            challenger = char - '1'
+        }
+    }
+    if (inputs.mouseButtons[0] & 1) == 1 {
+        for i := 0; i < 4; i++ {
+            r := game.getScoreRect(i)
+            if r.contains(inputs.cursorX, inputs.cursorY) {
+                challenger = int32(i)
             }
+        }
+    }
+
+    if challenger < 0 || challenger == playerIdx || game.players[challenger].kind == PLAYER_INACTIVE {
+        return -1
+    }
+    return challenger
+}
+
+// puts the tiles that were played back in the slots that were empty before the draw
+func (game *Game) withdrawPlay(p *Player, returned []int16) {
+    deck := game.rackBeforeDraw
+    n := 0
+    for j := 0; j < game.menu.rackSize && n < len(returned); j++ {
+        if getRackTile(deck, j) == 0 {
+            deck = setRackTile(deck, j, returned[n])
+            n++
+        }
+    }
+
+    p.deckTilesBits.cur = deck
+    p.deckTilesBits.prev = deck
+    p.deckTilesBits.animPos = 0
+    p.deckTilesBits.animLen = 0
+}
 
-            tile := int32(game.boardTiles[pos])
-            game.wordBuilder.WriteByte(byte(0x40 + (tile & 0x1f)))
+func (game *Game) resolveChallenge(playerIdx, challenger int32) {
+    game.isChallengeable = false
+    p := &game.players[playerIdx]
 
-            letterScore := int32(0)
-            if tile < 32 {
-                letterScore = tiles[tile - 1].points
+    res, err := game.rules.Apply(engine.Move{engine.MOVE_CHALLENGE, challenger, nil, nil})
+    if err != nil {
+        game.showMessage(err.Error())
+        return
+    }
+    if res.Skipped >= 0 {
+        game.skippedPlayer = res.Skipped
+    }
+
+    if res.IsUpheld {
+        rejected := ""
+        for i, w := range res.Words {
+            if i > 0 {
+                rejected += ", "
             }
-            game.scoringCommands = append(game.scoringCommands, uint16(pos << 8 | letterScore))
+            rejected += w.Text
+        }
 
-            if isNewTile {
-                tt := getTileType(int32(pos % 15), int32(pos / 15))
-                cmd := uint16(pos << 8 | BONUS)
-                if tt == TRIPLE_LETTER || tt == TRIPLE_WORD {
-                    cmd |= 3
-                } else {
-                    cmd |= 2
-                }
-                if tt == DOUBLE_LETTER || tt == TRIPLE_LETTER {
-                    game.scoringCommands = append(game.scoringCommands, cmd)
-                    letterScore *= int32(cmd & 3)
-                } else if tt == DOUBLE_WORD || tt == TRIPLE_WORD {
-                    wordMultipliers = wordMultipliers << 16 | uint64(cmd)
-                }
+        game.withdrawPlay(p, res.Returned)
+        p.turnScore = 0
+        game.scoringCommands = game.scoringCommands[0:0]
+        game.state.animPos = 0
+        game.state.animLen = 0
+        game.showMessage("Challenge upheld, not valid: " + rejected)
+        return
+    }
+
+    msg := "Challenge failed"
+    if game.rules.ChallengeRule == engine.CHALLENGE_DOUBLE {
+        msg += ", player " + strconv.Itoa(int(challenger) + 1) + " loses their next turn"
+    } else if game.rules.ChallengeRule == engine.CHALLENGE_POINTS {
+        msg += ", player " + strconv.Itoa(int(challenger) + 1) + " loses " + strconv.Itoa(int(res.Penalty)) + " points"
+    }
+    game.updateScores()
+    game.showMessage(msg)
+}
+
+// the rules have already added the score for the turn being shown, so it's held back until the animation is over
+func (game *Game) updateScores() {
+    for i := 0; i < 4; i++ {
+        p := &game.players[i]
+        p.totalScore = game.rules.Scores[i] - p.turnScore
+    }
+}
+
+func (game *Game) simulateScoringTurn(inputs *Inputs, playerIdx int32) {
+    p := &game.players[playerIdx]
+
+    if game.isChallengeable {
+        challenger := game.getChallenger(inputs, playerIdx)
+        if challenger >= 0 {
+            game.resolveChallenge(playerIdx, challenger)
+        }
+    }
+
+    p.deckTilesBits.step()
+    if game.state.animLen == 0 && p.deckTilesBits.animLen == 0 {
+        game.isChallengeable = false
+        p.turnScore = 0
+        game.updateScores()
+
+        for i := 0; i < engine.MAX_RACK_SIZE; i++ {
+            p.turnLetters[i] = 0
+            p.turnPositions[i] = 0
+        }
+        game.scoringCommands = game.scoringCommands[0:0]
+
+        game.updateCursor(inputs)
+
+        if game.rules.IsOver {
+            game.finishGame()
+            return
+        }
+        if game.skippedPlayer >= 0 {
+            game.showMessage("Player " + strconv.Itoa(int(game.skippedPlayer) + 1) + " loses their turn")
+        }
+
+        game.beginTurn(game.rules.Turn)
+        game.autosave()
+    }
+}
+
+// the rules have already taken the unplayed tiles off the scores, but the clock is only known here
+func (game *Game) finishGame() {
+    if game.menu.clockMode == CLOCK_GAME {
+        for i := int32(0); i < 4; i++ {
+            p := &game.players[i]
+            penalty := game.getOvertimePenalty(p)
+            if p.kind != PLAYER_INACTIVE && penalty > 0 {
+                game.rules.AddTimePenalty(i, penalty)
             }
+        }
+    }
+
+    err := os.WriteFile(RECORD_FILE, []byte(game.rules.WriteGCG()), 0666)
+    if err != nil {
+        game.showMessage("Couldn't save the game to " + RECORD_FILE)
+    }
+    game.removeAutosaves()
+    game.showResults()
+}
+
+func (game *Game) showResults() {
+    game.updateScores()
+    game.state.prev = game.state.cur
+    game.state.cur = GAME_OVER
+    game.state.animPos = 0
+    game.state.animLen = RESULTS_DURATION
+}
+
+// the record is read once up front so that a bad one is reported straight away, and again each time it's watched,
+// since a game played in between replaces it
+func (game *Game) loadReplay(lines []string) error {
+    err := game.rules.ReadGCG(lines)
+    if err != nil {
+        return err
+    }
+    game.replay = lines
+    return nil
+}
 
-            wordScore += int(letterScore)
-            pos += xInc + 15 * yInc
+func (game *Game) startReplay() error {
+    err := game.rules.ReadGCG(game.replay)
+    if err != nil {
+        return err
+    }
+    game.isReplay = true
+    for i := 0; i < 4; i++ {
+        p := &game.players[i]
+        p.kind = PLAYER_INACTIVE
+        if game.rules.IsActive[i] {
+            p.kind = PLAYER_REAL
         }
+        p.turnScore = 0
+        p.nTilesHeld = 0
+        p.nTilesStaged = 0
+        p.turnOffsetsBits.reset()
+        p.deckTilesBits.reset()
+    }
 
-        game.scoringWords = append(game.scoringWords, game.wordBuilder.String())
+    game.isResultsDismissed = false
+    game.isChallengeable = false
+    game.scoringCommands = game.scoringCommands[0:0]
+    game.replayPos = 0
+    game.rules.ReplayTo(0)
+    game.updateScores()
 
-        for wordMultipliers != 0 {
-            game.scoringCommands = append(game.scoringCommands, uint16(wordMultipliers & 0xffff))
-            wordScore *= int(wordMultipliers & 3)
-            wordMultipliers >>= 16
+    game.state.prev = 0
+    game.state.cur = REPLAY
+    return nil
+}
+
+// the arrow keys step through the game one event at a time, showing the rack the player had beforehand
+func (game *Game) simulateReplay(inputs *Inputs) {
+    history := game.rules.History
+    pos := game.replayPos
+    for _, code := range inputs.pressedKeys {
+        if code == KEY_RIGHT && pos < len(history) {
+            pos++
+        } else if code == KEY_LEFT && pos > 0 {
+            pos--
+        } else if code == KEY_ESCAPE || code == KEY_RETURN {
+            game.rules.ReplayTo(len(history))
+            game.showResults()
+            return
         }
+    }
+    if pos == game.replayPos {
+        return
+    }
 
-        totalScore += wordScore
+    game.replayPos = pos
+    game.rules.ReplayTo(pos)
+    game.updateScores()
+    if pos == 0 {
+        game.state.cur = REPLAY
+        return
+    }
+
+    ev := &history[pos - 1]
+    score := strconv.Itoa(int(ev.Score))
+    if ev.Score >= 0 {
+        score = "+" + score
     }
+    game.showMessage("Player " + strconv.Itoa(int(ev.Player) + 1) + ": " + ev.Notation + " " + score)
 
-    usedAllTilesInDeck := true
-    for i := 0; i < 7; i++ {
-        if p.turnPositions[i] == 0 {
-            usedAllTilesInDeck = false
-            break
+    p := &game.players[ev.Player]
+    p.deckTilesBits.cur = makeRack(ev.Rack)
+    p.deckTilesBits.prev = p.deckTilesBits.cur
+    game.state.cur = uint64(REPLAY | ev.Player)
+}
+
+func (game *Game) simulatePauseMenu(inputs *Inputs) {
+    choice := -1
+    if (inputs.mouseButtons[0] & 1) == 1 {
+        choice = game.getMenuEntryAt(inputs.cursorX, inputs.cursorY, len(pauseMenuLabels))
+        inputs.mouseButtons[0] &= ^1
+    }
+    for _, code := range inputs.pressedKeys {
+        if code == KEY_ESCAPE {
+            choice = PAUSE_RESUME
         }
     }
 
-    if usedAllTilesInDeck {
-        cmd := uint16((int(p.turnPositions[6]) - 1) << 8 | 50)
-        game.scoringCommands = append(game.scoringCommands, cmd)
-        totalScore += 50
+    if choice == PAUSE_RESUME {
+        game.isMenuOpen = false
+    } else if choice == PAUSE_SAVE_GAME {
+        err := game.saveGame(SAVE_FILE)
+        if err != nil {
+            game.showMessage(err.Error())
+        } else {
+            game.showMessage("Saved the game to " + SAVE_FILE)
+        }
+        game.isMenuOpen = false
+    } else if choice == PAUSE_LOAD_GAME {
+        err := game.loadGame(SAVE_FILE)
+        if err != nil {
+            game.showMessage(err.Error())
+        }
+        game.isMenuOpen = false
     }
+}
 
-    return totalScore
+func (game *Game) simulateGameOver(inputs *Inputs) {
+    if game.state.animLen > 0 {
+        return
+    }
+    if (inputs.mouseButtons[0] & 1) == 1 {
+        game.isResultsDismissed = true
+    }
+    for _, code := range inputs.pressedKeys {
+        if code == KEY_RETURN {
+            game.isResultsDismissed = true
+        }
+    }
 }
 
 func (a *Animation) step() (justCompleted bool) {
@@ -6,9 +6,11 @@ import (
 	"strconv"
 	"image/color"
 	rl "github.com/gen2brain/raylib-go/raylib"
+	"scrambles/engine"
 )
 
 import "fmt"
+import "flag"
 
 const fps = 60
 
@@ -23,6 +25,9 @@ const KEY_UP = rl.KeyUp
 const KEY_DOWN = rl.KeyDown
 const KEY_LEFT = rl.KeyLeft
 const KEY_RIGHT = rl.KeyRight
+const KEY_ESCAPE = rl.KeyEscape
+const KEY_PASS = rl.KeyF2
+const KEY_EXCHANGE = rl.KeyF3
 
 const ARROW_UP = 0
 const ARROW_DOWN = 1
@@ -39,6 +44,7 @@ type Textures struct {
 	letterGlyphsLarge []rl.GlyphInfo
 	numberGlyphsSmall []rl.GlyphInfo
 	numberGlyphsLarge []rl.GlyphInfo
+	letterGlyphIndex map[rune]int
 	fontDataTiles []byte
 	fontDataUi []byte
 	smallTileSize int
@@ -52,6 +58,9 @@ var boardTileColorsRgba = [...]uint32 {
     0xe00000ff,
     0x80d0ffff,
     0x00a0e0ff,
+    0x9040d0ff,
+    0xa00050ff,
+    0x0058b0ff,
 }
 
 var playerDeckColors = [...]color.RGBA {
@@ -61,6 +70,16 @@ var playerDeckColors = [...]color.RGBA {
     {0, 64, 16, 255},
 }
 
+var blankLetterColor = color.RGBA{0, 96, 208, 255}
+
+// the small tile texture holds every letter and the empty blank, followed by each letter a blank can stand for
+func getTileTextureIndex(game *Game, tile int16) int {
+    if (tile & engine.BLANK_FLAG) != 0 {
+        return int(game.rules.BlankTile) + int(tile & engine.LETTER_MASK) - 1
+    }
+    return int(tile) - 1
+}
+
 func updateColor(color *color.RGBA, rgba uint32) {
 	color.R = uint8((rgba >> 24) & 0xff)
 	color.G = uint8((rgba >> 16) & 0xff)
@@ -72,25 +91,85 @@ func secsToFrames(seconds int) int {
     return seconds * fps
 }
 
-func drawMenu(game *Game, inputs *Inputs, isMenuActive bool) (shouldStartGame bool) {
-    // menu drawing goes here
+// the clocks are saved in milliseconds, so that saved games don't depend on the frame rate
+func framesToMillis(frames int32) int {
+    return int(frames) * 1000 / fps
+}
+
+func millisToFrames(millis int) int32 {
+    return int32(millis * fps / 1000)
+}
+
+func formatClock(frames int32) string {
+    secs := (frames + fps - 1) / fps
+    str := strconv.Itoa(int(secs / 60)) + ":"
+    if secs % 60 < 10 {
+        str += "0"
+    }
+    return str + strconv.Itoa(int(secs % 60))
+}
 
+// returns one of the MENU_ entries once it has been picked, otherwise -1
+func drawMenu(game *Game, inputs *Inputs, isMenuActive bool) (choice int) {
 	if !isMenuActive {
-		return false
+		return -1
 	}
 
-    game.menu.timeLimitSecs = 120
+    // validating against an empty word list would reject every play
+    if game.rules.Lexicon.Size() == 0 {
+        game.menu.shouldValidateEveryWord = false
+    }
     for i := 0; i < 2; i++ {
         game.players[i].kind = PLAYER_REAL
         game.players[i+2].kind = PLAYER_INACTIVE
     }
 
-	shouldStartGame = false
+	entries := []int{MENU_NEW_GAME, MENU_LOAD_GAME}
+	if game.hasAutosave {
+		entries = append(entries, MENU_RESUME_GAME)
+	}
+	if game.replay != nil {
+		entries = append(entries, MENU_WATCH_REPLAY)
+	}
+	var labels []string
+	for _, entry := range entries {
+		labels = append(labels, startMenuLabels[entry])
+	}
+	drawMenuEntries(game, inputs, labels)
+	if game.messageTimer > 0 {
+		game.messageTimer--
+		drawMessage(game, game.message)
+	}
+
+	choice = -1
 	if (inputs.mouseButtons[0] & 1) == 1 {
-		shouldStartGame = true
+		idx := game.getMenuEntryAt(inputs.cursorX, inputs.cursorY, len(labels))
+		if idx >= 0 {
+			choice = entries[idx]
+		}
+	}
+	for _, code := range inputs.pressedKeys {
+		if code == KEY_RETURN {
+			choice = MENU_NEW_GAME
+		}
 	}
 
-	return shouldStartGame
+	return choice
+}
+
+func drawMenuEntries(game *Game, inputs *Inputs, labels []string) {
+    for i := 0; i < len(labels); i++ {
+        r := game.getMenuEntryRect(i, len(labels))
+        c := color.RGBA{0, 48, 24, 255}
+        if r.contains(inputs.cursorX, inputs.cursorY) {
+            c = color.RGBA{0, 96, 48, 255}
+        }
+        rl.DrawRectangle(r.x, r.y, r.w, r.h, c)
+
+        textSize := r.h / 2
+        textW := rl.MeasureText(labels[i], textSize)
+        rl.DrawText(labels[i], r.x + (r.w - textW) / 2, r.y + (r.h - textSize) / 2, textSize, rl.White)
+    }
 }
 
 func drawBoard(game *Game, boardTex rl.Texture2D, tBoardFall float32) {
@@ -98,7 +177,7 @@ func drawBoard(game *Game, boardTex rl.Texture2D, tBoardFall float32) {
 		return
 	}
 
-    boardLen := game.tileSize * 15
+    boardLen := game.tileSize * int32(game.rules.Layout.Size)
     var xOff int32 = int32(game.wndWidth - boardLen) / 2
     var yOff int32 = int32(game.wndHeight - boardLen - 2 * game.tileSize) / 2
 
@@ -120,6 +199,26 @@ func drawBoard(game *Game, boardTex rl.Texture2D, tBoardFall float32) {
 	boardTint.A = uint8(int(t2 * 255.0) & 0xff)
 
 	rl.DrawTexturePro(boardTex, srcRect, dstRect, origin, boardRotation, boardTint)
+	drawBoardLabels(game, xOff, yOff, uint8(int(t4 * 255.0) & 0xff))
+}
+
+// the column letters go along the top of the board and the row numbers down the left, to match the move notation
+func drawBoardLabels(game *Game, xOff, yOff int32, alpha uint8) {
+    size := game.rules.Layout.Size
+    tileSize := game.tileSize
+    textSize := max(tileSize / 3, 8)
+    pad := textSize / 3
+    c := color.RGBA{192, 240, 255, alpha}
+
+    for i := 0; i < size; i++ {
+        col := engine.FormatColumn(i)
+        w := rl.MeasureText(col, textSize)
+        rl.DrawText(col, xOff + int32(i) * tileSize + (tileSize - w) / 2, yOff - textSize - pad, textSize, c)
+
+        row := strconv.Itoa(i + 1)
+        w = rl.MeasureText(row, textSize)
+        rl.DrawText(row, xOff - w - pad, yOff + int32(i) * tileSize + (tileSize - textSize) / 2, textSize, c)
+    }
 }
 
 func drawGame(game *Game, textures *Textures, inputs *Inputs) (isGameOver bool) {
@@ -133,26 +232,38 @@ func drawGame(game *Game, textures *Textures, inputs *Inputs) (isGameOver bool)
     pos := rl.Vector2{}
 
     tileSize := int(game.tileSize)
-    boardLen := tileSize * 15
+    boardLen := tileSize * game.rules.Layout.Size
     xBoardOff := (int(game.wndWidth) - boardLen) / 2
     yBoardOff := (int(game.wndHeight) - boardLen - 2 * tileSize) / 2
     tileOff := (tileSize - textures.smallTileSize) / 2
 
     {
         textSize := min(game.wndWidth, game.wndHeight) / 32
-        wScore := textSize * 4
-        hScore := (textSize * 5) / 4
-        xScore := int32(xBoardOff) - wScore - textSize
-        yScore := int32(yBoardOff) + textSize
-        textOff := hScore / 2
 
         for i := 0; i < 4; i++ {
             if game.players[i].kind == PLAYER_INACTIVE {
                 continue
             }
-            rl.DrawRectangle(xScore, yScore, wScore, hScore, playerDeckColors[i])
+            r := game.getScoreRect(i)
+            xScore := r.x
+            yScore := r.y
+            textOff := r.h / 2
+            rl.DrawRectangle(xScore, yScore, r.w, r.h, playerDeckColors[i])
             rl.DrawText(strconv.Itoa(int(game.players[i].totalScore)), xScore + textOff, yScore + textOff, textSize, rl.White)
-            yScore += hScore + (textSize / 2)
+            yScore += r.h + (textSize / 2)
+
+            if game.menu.clockMode != CLOCK_NONE && !game.isReplay {
+                p := &game.players[i]
+                clockColor := rl.White
+                clockStr := formatClock(p.clockFrames)
+                if p.overtimeFrames > 0 {
+                    clockStr = "-" + formatClock(p.overtimeFrames)
+                    clockColor = color.RGBA{255, 96, 96, 255}
+                } else if mode == PLAYER_TURN && int32(i) == player && p.clockFrames < int32(secsToFrames(10)) {
+                    clockColor = color.RGBA{255, 96, 96, 255}
+                }
+                rl.DrawText(clockStr, xScore + textOff, yScore, textSize, clockColor)
+            }
         }
     }
 
@@ -161,12 +272,15 @@ func drawGame(game *Game, textures *Textures, inputs *Inputs) (isGameOver bool)
         boardCurY := int(game.turnCursorY) - yBoardOff
         col := boardCurX / tileSize
         row := boardCurY / tileSize
-        if boardCurX >= 0 && boardCurY >= 0 && col >= 0 && col < 15 && row >= 0 && row < 15 {
+        if boardCurX >= 0 && boardCurY >= 0 && col >= 0 && col < game.rules.Layout.Size && row >= 0 && row < game.rules.Layout.Size {
             xHl := int32(xBoardOff + (col * tileSize) - textures.tileHlBorderSize)
             yHl := int32(yBoardOff + (row * tileSize) - textures.tileHlBorderSize)
             rl.DrawTexture(textures.tileHl, xHl, yHl, color.RGBA{255, 240, 160, 255})
         }
 
+        drawButtons(game, inputs)
+        drawRotateHandle(game, inputs, player)
+
         if game.shuffleTimer > 0 {
             t := float32(game.shuffleTimer) / float32(SHUFFLE_DURATION)
             drawDeck(game, textures, player, t, DECK_SHUFFLE)
@@ -183,16 +297,13 @@ func drawGame(game *Game, textures *Textures, inputs *Inputs) (isGameOver bool)
         }
     }
 
-    for i := 0; i < 15 * 15; i++ {
-        tileIndex := int(game.boardTiles[i]) - 1
+    for i := 0; i < len(game.rules.Board); i++ {
+        tileIndex := getTileTextureIndex(game, game.rules.Board[i])
         if tileIndex < 0 {
             continue
         }
-        if tileIndex >= 32 {
-		    tileIndex -= 5
-		}
-        x := i % 15
-        y := i / 15
+        x := i % game.rules.Layout.Size
+        y := i / game.rules.Layout.Size
         pos.X = float32(xBoardOff + tileOff + (x * tileSize))
         pos.Y = float32(yBoardOff + tileOff + (y * tileSize))
         rect.X = float32((tileIndex % 9) * textures.smallTileSize)
@@ -200,8 +311,30 @@ func drawGame(game *Game, textures *Textures, inputs *Inputs) (isGameOver bool)
         rl.DrawTextureRec(textures.tilesSmall, rect, pos, rl.White)
     }
 
+    if mode == PLAYER_TURN {
+        p := &game.players[player]
+        for i := 0; i < int(p.nTilesStaged); i++ {
+            tileIndex := getTileTextureIndex(game, p.stagedLetters[i])
+            x := (int(p.stagedPositions[i]) - 1) % game.rules.Layout.Size
+            y := (int(p.stagedPositions[i]) - 1) / game.rules.Layout.Size
+            xHl := int32(xBoardOff + (x * tileSize) - textures.tileHlBorderSize)
+            yHl := int32(yBoardOff + (y * tileSize) - textures.tileHlBorderSize)
+            rl.DrawTexture(textures.tileHl, xHl, yHl, color.RGBA{255, 160, 64, 255})
+
+            pos.X = float32(xBoardOff + tileOff + (x * tileSize))
+            pos.Y = float32(yBoardOff + tileOff + (y * tileSize))
+            rect.X = float32((tileIndex % 9) * textures.smallTileSize)
+            rect.Y = float32((tileIndex / 9) * textures.smallTileSize)
+            rl.DrawTextureRec(textures.tilesSmall, rect, pos, rl.White)
+        }
+    }
+
+    if mode == PLAYER_TURN && game.isChoosingBlank {
+        drawBlankChooser(game, textures, inputs)
+    }
+
     if mode == PICK_ORDER {
-        // TODO
+        drawPickOrder(game, textures)
     } else if mode == PLAYER_TURN {
         if game.players[player].nTilesHeld == 0 {
             rl.DrawTexture(textures.tileCursor, int32(game.turnCursorX - tileW * 0.5), int32(game.turnCursorY - tileW * 0.5), rl.White)
@@ -212,12 +345,279 @@ func drawGame(game *Game, textures *Textures, inputs *Inputs) (isGameOver bool)
         //fmt.Println(game.state.animPos)
         drawDeck(game, textures, player, 1.0, DECK_REFILL)
         drawScoring(game, textures, player, rect)
+        if game.isChallengeable {
+            drawChallengePrompt(game)
+        }
+    } else if mode == GAME_OVER {
+        drawResults(game)
+    } else if mode == REPLAY && game.replayPos > 0 {
+        drawDeck(game, textures, player, 1.0, DECK_REFILL)
+    }
+
+    if game.isMenuOpen {
+        rl.DrawRectangle(0, 0, game.wndWidth, game.wndHeight, color.RGBA{0, 0, 0, 160})
+        drawMenuEntries(game, inputs, pauseMenuLabels[:])
+    }
+
+    if game.messageTimer > 0 {
+        drawMessage(game, game.message)
     }
 
-	isGameOver = false
+	isGameOver = mode == GAME_OVER && game.isResultsDismissed
 	return isGameOver
 }
 
+func drawMessage(game *Game, msg string) {
+    textSize := min(game.wndWidth, game.wndHeight) / 32
+    textW := rl.MeasureText(msg, textSize)
+    pad := textSize / 2
+
+    tileSize := int32(game.tileSize)
+    boardLen := tileSize * int32(game.rules.Layout.Size)
+    yBoardOff := (game.wndHeight - boardLen - 2 * tileSize) / 2
+
+    x := (game.wndWidth - textW) / 2
+    y := yBoardOff + (boardLen - textSize) / 2
+    alpha := uint8(min(game.messageTimer * 8, 255))
+    rl.DrawRectangle(x - pad, y - pad, textW + 2 * pad, textSize + 2 * pad, color.RGBA{0, 0, 0, alpha / 4 * 3})
+    rl.DrawText(msg, x, y, textSize, color.RGBA{255, 255, 255, alpha})
+}
+
+func drawPickOrder(game *Game, textures *Textures) {
+    t := game.state.getPositionOr(1.0)
+    tDrop := min(t * 2.0, 1.0)
+
+    tileW := float32(textures.largeTileSize)
+    tileRect := rl.Rectangle{0, 0, tileW, tileW}
+    dstRect := tileRect
+    origin := rl.Vector2{}
+
+    nPlayers := int32(0)
+    for i := 0; i < 4; i++ {
+        if game.players[i].kind != PLAYER_INACTIVE {
+            nPlayers++
+        }
+    }
+
+    pad := int32(tileW * 0.25)
+    boxSize := int32(tileW) + 2 * pad
+    rowW := nPlayers * boxSize + (nPlayers - 1) * pad
+
+    tileSize := game.tileSize
+    boardLen := tileSize * int32(game.rules.Layout.Size)
+    xBox := (game.wndWidth - rowW) / 2
+    yBox := (game.wndHeight - boardLen - 2 * tileSize) / 2 + (boardLen - boxSize) / 2
+
+    // only show who won the round once every tile has landed
+    leaders := int32(0)
+    if t >= 0.75 {
+        leaders = game.getOrderLeaders()
+    }
+
+    for i := 0; i < 4; i++ {
+        if game.players[i].kind == PLAYER_INACTIVE {
+            continue
+        }
+
+        boxColor := playerDeckColors[i]
+        isContender := (game.orderContenders & (1 << i)) != 0
+        if !isContender {
+            boxColor.A = 96
+        }
+        rl.DrawRectangle(xBox, yBox, boxSize, boxSize, boxColor)
+        if (leaders & (1 << i)) != 0 {
+            rl.DrawRectangleLinesEx(rl.Rectangle{float32(xBox), float32(yBox), float32(boxSize), float32(boxSize)}, float32(pad) * 0.5, rl.White)
+        }
+
+        tileIndex := int(game.orderTiles[i]) - 1
+        if tileIndex >= 0 {
+            drop := float32(0.0)
+            if isContender {
+                drop = (1.0 - tDrop) * (1.0 - tDrop)
+            }
+            tileRect.X = float32((tileIndex % 9) * textures.largeTileSize)
+            tileRect.Y = float32((tileIndex / 9) * textures.largeTileSize)
+            dstRect.X = float32(xBox + pad)
+            dstRect.Y = float32(yBox + pad) - drop * float32(yBox + boxSize)
+            rl.DrawTexturePro(textures.tilesLarge, tileRect, dstRect, origin, 0.0, rl.White)
+        }
+
+        xBox += boxSize + pad
+    }
+
+    if leaders != 0 {
+        msg := "Tie! Draw again"
+        if (leaders & (leaders - 1)) == 0 {
+            first := 0
+            for (leaders & (1 << first)) == 0 {
+                first++
+            }
+            msg = "Player " + strconv.Itoa(first + 1) + " goes first"
+        }
+        textSize := min(game.wndWidth, game.wndHeight) / 32
+        textW := rl.MeasureText(msg, textSize)
+        rl.DrawText(msg, (game.wndWidth - textW) / 2, yBox + boxSize + textSize, textSize, rl.White)
+    }
+}
+
+func drawChallengePrompt(game *Game) {
+    msg := "Challenge? Press your player number or click your score"
+    layout := game.getDeckLayout()
+    textSize := min(game.wndWidth, game.wndHeight) / 40
+    textW := rl.MeasureText(msg, textSize)
+    y := layout.rect.y - textSize * 2
+    rl.DrawText(msg, (game.wndWidth - textW) / 2, y, textSize, rl.White)
+
+    t := game.state.getPositionOr(1.0)
+    barW := int32(float32(textW) * (1.0 - t))
+    rl.DrawRectangle((game.wndWidth - barW) / 2, y + textSize + textSize / 4, barW, textSize / 4, rl.White)
+}
+
+func drawResults(game *Game) {
+    t := game.state.getPositionOr(1.0)
+    textSize := min(game.wndWidth, game.wndHeight) / 24
+    lineH := (textSize * 3) / 2
+    pad := textSize
+
+    winningScore := int32(math.MinInt32)
+    nPlayers := int32(0)
+    for i := 0; i < 4; i++ {
+        if game.players[i].kind != PLAYER_INACTIVE {
+            winningScore = max(winningScore, game.players[i].totalScore)
+            nPlayers++
+        }
+    }
+
+    panelW := textSize * 16
+    panelH := (nPlayers + 2) * lineH + pad
+    x := (game.wndWidth - panelW) / 2
+    y := (game.wndHeight - panelH) / 2
+    alpha := uint8(t * 255.0)
+    rl.DrawRectangle(x, y, panelW, panelH, color.RGBA{0, 0, 0, alpha / 4 * 3})
+
+    title := "Game over"
+    rl.DrawText(title, x + (panelW - rl.MeasureText(title, textSize)) / 2, y + pad, textSize, color.RGBA{255, 255, 255, alpha})
+    y += pad + lineH
+
+    for i := 0; i < 4; i++ {
+        p := &game.players[i]
+        if p.kind == PLAYER_INACTIVE {
+            continue
+        }
+        c := playerDeckColors[i]
+        c.A = alpha
+        rl.DrawRectangle(x + pad, y, lineH - textSize / 4, lineH - textSize / 4, c)
+
+        line := "Player " + strconv.Itoa(i + 1) + ": " + strconv.Itoa(int(p.totalScore))
+        endAdjustment := game.rules.EndAdjustments[i]
+        if endAdjustment > 0 {
+            line += "  (+" + strconv.Itoa(int(endAdjustment)) + ")"
+        } else if endAdjustment < 0 {
+            line += "  (" + strconv.Itoa(int(endAdjustment)) + ")"
+        }
+        timePenalty := game.rules.TimePenalties[i]
+        if timePenalty > 0 {
+            line += "  (-" + strconv.Itoa(int(timePenalty)) + " time)"
+        }
+        if p.totalScore == winningScore {
+            line += "  - winner!"
+        }
+        rl.DrawText(line, x + pad + lineH, y, textSize, color.RGBA{255, 255, 255, alpha})
+        y += lineH
+    }
+}
+
+func drawButtons(game *Game, inputs *Inputs) {
+    labels := [...]string{"Pass", "Swap", "Play", "Recall"}
+    if game.isExchanging {
+        labels[BUTTON_EXCHANGE] = "Done"
+    }
+
+    for i := 0; i < len(labels); i++ {
+        r := game.getButtonRect(i)
+        c := color.RGBA{0, 48, 24, 255}
+        if r.contains(inputs.cursorX, inputs.cursorY) {
+            c = color.RGBA{0, 96, 48, 255}
+        }
+        if i == BUTTON_EXCHANGE && game.isExchanging {
+            c = color.RGBA{160, 112, 0, 255}
+        }
+        rl.DrawRectangle(r.x, r.y, r.w, r.h, c)
+
+        textSize := r.h / 2
+        textW := rl.MeasureText(labels[i], textSize)
+        rl.DrawText(labels[i], r.x + (r.w - textW) / 2, r.y + (r.h - textSize) / 2, textSize, rl.White)
+    }
+
+    if game.isExchanging {
+        msg := "Choose tiles to exchange, then press Enter"
+        if len(game.rules.Bag) < game.menu.rackSize {
+            msg = "Too few tiles left in the bag to exchange"
+        }
+        layout := game.getDeckLayout()
+        textSize := min(game.wndWidth, game.wndHeight) / 40
+        textW := rl.MeasureText(msg, textSize)
+        rl.DrawText(msg, (game.wndWidth - textW) / 2, layout.rect.y - textSize - textSize / 2, textSize, rl.White)
+    }
+}
+
+func drawBlankChooser(game *Game, textures *Textures, inputs *Inputs) {
+    panel := game.getBlankChooserRect(-1)
+    rl.DrawRectangle(panel.x, panel.y, panel.w, panel.h, color.RGBA{0, 0, 0, 200})
+
+    msg := "Choose a letter for the blank"
+    textSize := min(game.wndWidth, game.wndHeight) / 40
+    textW := rl.MeasureText(msg, textSize)
+    rl.DrawText(msg, panel.x + (panel.w - textW) / 2, panel.y + textSize / 2, textSize, rl.White)
+
+    tileW := float32(textures.smallTileSize)
+    srcRect := rl.Rectangle{0, 0, tileW, tileW}
+    for i := 0; i < game.rules.GetLetterCount(); i++ {
+        r := game.getBlankChooserRect(i)
+        if r.contains(inputs.cursorX, inputs.cursorY) {
+            rl.DrawTexture(textures.tileHl, r.x - int32(textures.tileHlBorderSize), r.y - int32(textures.tileHlBorderSize), color.RGBA{255, 240, 160, 255})
+        }
+        tileIndex := getTileTextureIndex(game, int16(i + 1) | engine.BLANK_FLAG)
+        srcRect.X = float32((tileIndex % 9) * textures.smallTileSize)
+        srcRect.Y = float32((tileIndex / 9) * textures.smallTileSize)
+        rl.DrawTextureRec(textures.tilesSmall, srcRect, rl.Vector2{float32(r.x), float32(r.y)}, rl.White)
+    }
+}
+
+func drawRotateHandle(game *Game, inputs *Inputs, playerIdx int32) {
+    r := game.getRotateHandleRect()
+    c := color.RGBA{0, 48, 24, 255}
+    if r.contains(inputs.cursorX, inputs.cursorY) {
+        c = color.RGBA{0, 96, 48, 255}
+    }
+    rl.DrawRectangle(r.x, r.y, r.w, r.h, c)
+
+    // the arrow turns along with the held tiles
+    p := &game.players[playerIdx]
+    t := p.turnState.getPositionOr(1.0)
+    angle := float32(0.0)
+    if p.turnState.cur == ROTA_VERT {
+        angle = 90.0 * t
+    } else {
+        angle = 90.0 * (1.0 - t)
+    }
+
+    w := float32(r.w)
+    centre := rl.Vector2{float32(r.x) + w * 0.5, float32(r.y) + w * 0.5}
+    shaft := rl.Rectangle{centre.X, centre.Y, w * 0.6, w * 0.12}
+    rl.DrawRectanglePro(shaft, rl.Vector2{w * 0.3, w * 0.06}, angle, rl.White)
+
+    rad := float64(angle) * math.Pi / 180.0
+    dirX := float32(math.Cos(rad))
+    dirY := float32(math.Sin(rad))
+    tip := rl.Vector2{centre.X + dirX * w * 0.4, centre.Y + dirY * w * 0.4}
+    back := rl.Vector2{centre.X + dirX * w * 0.15, centre.Y + dirY * w * 0.15}
+    side1 := rl.Vector2{back.X - dirY * w * 0.18, back.Y + dirX * w * 0.18}
+    side2 := rl.Vector2{back.X + dirY * w * 0.18, back.Y - dirX * w * 0.18}
+    rl.DrawTriangle(tip, side2, side1, rl.White)
+    rl.DrawTriangle(tip, side1, side2, rl.White)
+}
+
 func drawDeck(game *Game, textures *Textures, playerIdx int32, t float32, animMode int32) {
     tileRect := rl.Rectangle{0, 0, float32(textures.largeTileSize), float32(textures.largeTileSize)}
     dstRect := tileRect
@@ -232,35 +632,30 @@ func drawDeck(game *Game, textures *Textures, playerIdx int32, t float32, animMo
         }
     }
 
-	deckPadding := tileRect.Width * 0.25
-	tilesSpan := int32(7.0 * dstRect.Width + 6.0 * deckPadding)
-	deckSidePad := int32(float64(tilesSpan) * 0.05)
-
-    boardLen := int(game.tileSize) * 15
-    //xBoardOff := (int(game.wndWidth) - boardLen) / 2
-    yBoardOff := (int(game.wndHeight) - boardLen - 2 * int(game.tileSize)) / 2
-
-    leftoverH := int32(int(game.wndHeight) - yBoardOff - boardLen)
-	deckW := 2 * deckSidePad + tilesSpan
-	deckH := int32(dstRect.Height + deckPadding)
+    layout := game.getDeckLayout()
+	deckPadding := layout.tilePad
+	deckSidePad := layout.sidePad
+	deckW := layout.rect.w
+	deckH := layout.rect.h
     deckX := int32((1.0 - it2) * float32(game.wndWidth)) - ((game.wndWidth + deckW) / 2)
-    deckY := int32(yBoardOff + boardLen) + (leftoverH / 2) - (deckH / 2)
+    deckY := layout.rect.y
     rl.DrawRectangle(deckX, deckY, deckW, deckH, playerDeckColors[playerIdx])
 
     p := &game.players[playerIdx]
     origin := rl.Vector2{}
+    isExchanging := game.isExchanging && int32(game.state.cur & 3) == playerIdx
 
     if animMode == DECK_SHUFFLE {
-        for i := 0; i < 7; i++ {
-            prevTile := int((p.deckTilesBits.prev >> ((7-i-1)*8)) & 0x7f) - 1
+        for i := 0; i < game.menu.rackSize; i++ {
+            prevTile := int(getRackTile(p.deckTilesBits.prev, i)) - 1
             if prevTile < 0 {
                 continue
             }
 
             //xOff := float32(0.0)
-            //curTile := int((p.deckTilesBits.cur >> ((7-i-1)*8)) & 0x7f) - 1
+            //curTile := int(getRackTile(p.deckTilesBits.cur, i)) - 1
             //if prevTile != curTile {
-            dstIdx := 6 - game.shuffleBuf[i]
+            dstIdx := int8(game.menu.rackSize - 1) - game.shuffleBuf[i]
             xOff := float32(i) * t + float32(dstIdx) * (1.0 - t)
 
             tileRect.X = float32((prevTile % 9) * textures.largeTileSize)
@@ -270,32 +665,63 @@ func drawDeck(game *Game, textures *Textures, playerIdx int32, t float32, animMo
             rl.DrawTexturePro(textures.tilesLarge, tileRect, dstRect, origin, 0.0, rl.White)
         }
     } else {
-        for i := 0; i < 7; i++ {
+        for i := 0; i < game.menu.rackSize; i++ {
             t = 0.0
-            tileIndex := int((p.deckTilesBits.prev >> ((7-i-1)*8)) & 0x7f) - 1
+            tileIndex := int(getRackTile(p.deckTilesBits.prev, i)) - 1
 		    if tileIndex < 0 {
-		        tileIndex = int((p.deckTilesBits.cur >> ((7-i-1)*8)) & 0x7f) - 1
+		        tileIndex = int(getRackTile(p.deckTilesBits.cur, i)) - 1
 		        if tileIndex < 0 {
 			        continue
 		        }
 		        if p.deckTilesBits.animLen > 0 {
-		            t = min(float32(p.deckTilesBits.animPos - int32(i*2)) / float32(p.deckTilesBits.animLen - 14), 1.0)
+		            t = min(float32(p.deckTilesBits.animPos - int32(i*2)) / float32(p.deckTilesBits.animLen - int32(2 * game.menu.rackSize)), 1.0)
 		            t = (1.0 - t) * (1.0 - t)
 	            }
 		    }
 
+            tint := rl.White
+            if isExchanging && (game.exchangeSlots & (1 << i)) != 0 {
+                t = -0.3 / 5.0
+                tint = color.RGBA{160, 160, 160, 255}
+            }
+
             tileRect.X = float32((tileIndex % 9) * textures.largeTileSize)
             tileRect.Y = float32((tileIndex / 9) * textures.largeTileSize)
             dstRect.X = float32(deckX + deckSidePad) + float32(i) * (dstRect.Width + deckPadding)
             dstRect.Y = float32(deckY) + (t * dstRect.Height * 5.0)
-            rl.DrawTexturePro(textures.tilesLarge, tileRect, dstRect, origin, 0.0, rl.White)
+            rl.DrawTexturePro(textures.tilesLarge, tileRect, dstRect, origin, 0.0, tint)
         }
     }
 }
 
+func drawWordSpans(game *Game, spans []engine.Word) {
+    tileSize := game.tileSize
+    boardLen := tileSize * int32(game.rules.Layout.Size)
+    xBoardOff := (game.wndWidth - boardLen) / 2
+    yBoardOff := (game.wndHeight - boardLen - 2 * tileSize) / 2
+
+    for _, span := range spans {
+        c := color.RGBA{0, 224, 64, 110}
+        if !span.IsValid {
+            c = color.RGBA{240, 0, 0, 110}
+        }
+        size := int32(game.rules.Layout.Size)
+        x1 := int32(span.Start) % size
+        y1 := int32(span.Start) / size
+        x2 := int32(span.End) % size
+        y2 := int32(span.End) / size
+        rl.DrawRectangle(xBoardOff + x1 * tileSize, yBoardOff + y1 * tileSize, (x2 - x1 + 1) * tileSize, (y2 - y1 + 1) * tileSize, c)
+    }
+}
+
 func drawTurn(game *Game, textures *Textures, inputs *Inputs, playerIdx int32, tileRect rl.Rectangle) {
     origin := rl.Vector2{}
 
+    if game.menu.gameMode == MODE_XRAY {
+        drawWordSpans(game, game.previewWords)
+    }
+    drawWordSpans(game, game.invalidWords)
+
     p := &game.players[playerIdx]
     tTurnRot := float64(p.turnState.getPositionOr(0.0))
 
@@ -312,13 +738,10 @@ func drawTurn(game *Game, textures *Textures, inputs *Inputs, playerIdx int32, t
     nHeld := p.nTilesHeld
 
     for i := int32(0); i < nHeld; i++ {
-        tileIndex := int(p.turnLetters[i]) - 1
+        tileIndex := getTileTextureIndex(game, p.turnLetters[i])
 		if tileIndex < 0 {
 			break
 		}
-		if tileIndex >= 32 {
-		    tileIndex -= 5
-		}
 
 		offsetCur  += float32((p.turnOffsetsBits.cur >> ((nHeld-i-1)*4)) & 0xf)
 		offsetPrev += float32((p.turnOffsetsBits.prev >> ((nHeld-i-1)*4)) & 0xf)
@@ -341,7 +764,7 @@ func drawScoring(game *Game, textures *Textures, playerIdx int32, tileRect rl.Re
     }
 
     tileSize := int(game.tileSize)
-    boardLen := tileSize * 15
+    boardLen := tileSize * game.rules.Layout.Size
     xBoardOff := (int(game.wndWidth) - boardLen) / 2
     yBoardOff := (int(game.wndHeight) - boardLen - 2 * tileSize) / 2
     //tileOff := (tileSize - textures.smallTileSize) / 2
@@ -349,17 +772,17 @@ func drawScoring(game *Game, textures *Textures, playerIdx int32, tileRect rl.Re
     textSize := min(game.wndWidth, game.wndHeight) / 32
 
     cmd := game.scoringCommands[cmdIdx]
-    col := int((cmd >> 8) % 15)
-    row := int((cmd >> 8) / 15)
-    number := cmd & 0xff
+    col := cmd.pos % game.rules.Layout.Size
+    row := cmd.pos / game.rules.Layout.Size
 
     x := int32(xBoardOff + tileSize * (col + 1))
     y := int32(yBoardOff + tileSize * row)
-    rl.DrawText(game.scoreDisplayStrings[number], x, y, textSize, rl.Black)
+    rl.DrawText(cmd.text, x, y, textSize, rl.Black)
 }
 
-func maybeRecreateBoard(tex *rl.Texture2D, wndWidth, wndHeight, oldTileSize int32) (tileSize int32) {
-	tileSize = int32(min(wndWidth / 16, wndHeight / 20))
+func maybeRecreateBoard(game *Game, tex *rl.Texture2D, wndWidth, wndHeight, oldTileSize int32) (tileSize int32) {
+	size := int32(game.rules.Layout.Size)
+	tileSize = int32(min(wndWidth / (size + 1), wndHeight / (size + 5)))
     if tileSize == oldTileSize {
         return tileSize
     }
@@ -367,7 +790,7 @@ func maybeRecreateBoard(tex *rl.Texture2D, wndWidth, wndHeight, oldTileSize int3
 	c := color.RGBA{}
 	updateColor(&c, boardTileColorsRgba[0])
 
-	boardLen := int(tileSize * 15)
+	boardLen := int(tileSize * size)
 	canvas := rl.GenImageColor(boardLen, boardLen, c)
 
     topTri    := Triangle{tileSize / 2, -tileSize / 6, 2 * tileSize / 3, 0, tileSize / 3, 0}
@@ -376,17 +799,17 @@ func maybeRecreateBoard(tex *rl.Texture2D, wndWidth, wndHeight, oldTileSize int3
     bottomTri := Triangle{tileSize / 3, tileSize, 2 * tileSize / 3, tileSize, tileSize / 2, 7 * tileSize / 6}
 
     // draw tiles
-    for y := int32(0); y < 15; y++ {
-        for x := int32(0); x < 15; x++ {
-            tt := getTileType(x, y)
+    for y := int32(0); y < size; y++ {
+        for x := int32(0); x < size; x++ {
+            tt := game.rules.GetSquareType(x, y)
             if tt != 0 {
                 rgba := boardTileColorsRgba[tt]
                 updateColor(&c, rgba)
                 rl.ImageDrawRectangle(canvas, x * tileSize, y * tileSize, tileSize, tileSize, c)
-                renderTriangle(canvas.Data, tileSize * 15, tileSize * 15, x * tileSize, y * tileSize, &topTri, rgba)
-                renderTriangle(canvas.Data, tileSize * 15, tileSize * 15, x * tileSize, y * tileSize, &leftTri, rgba)
-                renderTriangle(canvas.Data, tileSize * 15, tileSize * 15, x * tileSize, y * tileSize, &rightTri, rgba)
-                renderTriangle(canvas.Data, tileSize * 15, tileSize * 15, x * tileSize, y * tileSize, &bottomTri, rgba)
+                renderTriangle(canvas.Data, tileSize * size, tileSize * size, x * tileSize, y * tileSize, &topTri, rgba)
+                renderTriangle(canvas.Data, tileSize * size, tileSize * size, x * tileSize, y * tileSize, &leftTri, rgba)
+                renderTriangle(canvas.Data, tileSize * size, tileSize * size, x * tileSize, y * tileSize, &rightTri, rgba)
+                renderTriangle(canvas.Data, tileSize * size, tileSize * size, x * tileSize, y * tileSize, &bottomTri, rgba)
             }
         }
     }
@@ -395,9 +818,9 @@ func maybeRecreateBoard(tex *rl.Texture2D, wndWidth, wndHeight, oldTileSize int3
     lineColor := color.RGBA{192, 240, 255, 255}
     lineWidth := tileSize / 12
     lineOff := tileSize - (lineWidth / 2)
-    for i := int32(0); i < 14; i++ {
-        rl.ImageDrawRectangle(canvas, 0, i * tileSize + lineOff, tileSize * 15, lineWidth, lineColor)
-        rl.ImageDrawRectangle(canvas, i * tileSize + lineOff, 0, lineWidth, tileSize * 15, lineColor)
+    for i := int32(0); i < size - 1; i++ {
+        rl.ImageDrawRectangle(canvas, 0, i * tileSize + lineOff, tileSize * size, lineWidth, lineColor)
+        rl.ImageDrawRectangle(canvas, i * tileSize + lineOff, 0, lineWidth, tileSize * size, lineColor)
     }
 
 	if tex.ID != 0 {
@@ -410,8 +833,8 @@ func maybeRecreateBoard(tex *rl.Texture2D, wndWidth, wndHeight, oldTileSize int3
     return tileSize
 }
 
-func updateTextures(textures *Textures, wndWidth, wndHeight, oldTileSize int32) (tileSize int32) {
-	tileSize = maybeRecreateBoard(&textures.board, wndWidth, wndHeight, oldTileSize)
+func updateTextures(game *Game, textures *Textures, wndWidth, wndHeight, oldTileSize int32) (tileSize int32) {
+	tileSize = maybeRecreateBoard(game, &textures.board, wndWidth, wndHeight, oldTileSize)
 	if tileSize == oldTileSize {
 		return tileSize
 	}
@@ -429,11 +852,18 @@ func updateTextures(textures *Textures, wndWidth, wndHeight, oldTileSize int32)
         rl.UnloadFontData(textures.numberGlyphsLarge)
     }
 
-	letterCodePoints := make([]int32, 26)
-	numberCodePoints := make([]int32, 10)
-	for i := 0; i < 26; i++ {
-		letterCodePoints[i] = int32(0x41 + i)
+	// a letter can be made of several characters, so only load the glyphs that the tile set actually uses
+	letterCodePoints := make([]int32, 0, 32)
+	textures.letterGlyphIndex = make(map[rune]int)
+	for i := 0; i < game.rules.GetLetterCount(); i++ {
+		for _, char := range game.rules.TileSet[i].Letter {
+			if _, exists := textures.letterGlyphIndex[char]; !exists {
+				textures.letterGlyphIndex[char] = len(letterCodePoints)
+				letterCodePoints = append(letterCodePoints, int32(char))
+			}
+		}
 	}
+	numberCodePoints := make([]int32, 10)
 	for i := 0; i < 10; i++ {
 		numberCodePoints[i] = int32(0x30 + i)
 	}
@@ -463,7 +893,7 @@ func updateTextures(textures *Textures, wndWidth, wndHeight, oldTileSize int32)
 		rl.FontDefault,
 	)
 
-    for i := 0; i < 26; i++ {
+    for i := 0; i < len(letterCodePoints); i++ {
         img := &textures.letterGlyphsSmall[i].Image
         rl.ImageFormat(img, rl.UncompressedR8g8b8a8)
         setAlphaToBrightness(img.Data, img.Width, img.Height)
@@ -482,10 +912,13 @@ func updateTextures(textures *Textures, wndWidth, wndHeight, oldTileSize int32)
         setAlphaToBrightness(img.Data, img.Width, img.Height)
     }
 
-    smallTileSize := min(tileSize - 1, int32(float64(tileSize) * 0.95))
-    largeTileSize := int32(1.4 * float64(smallTileSize))
-    tilesImageSmall := rl.GenImageColor(int(9 * smallTileSize), int(6 * smallTileSize), rl.White)
-    tilesImageLarge := rl.GenImageColor(int(9 * largeTileSize), int(3 * largeTileSize), rl.White)
+    nLetters := int32(game.rules.GetLetterCount())
+    smallRows := (2 * nLetters + 1 + 8) / 9
+    largeRows := (nLetters + 1 + 8) / 9
+
+    smallTileSize, largeTileSize := getTileTextureSizes(tileSize)
+    tilesImageSmall := rl.GenImageColor(int(9 * smallTileSize), int(smallRows * smallTileSize), rl.White)
+    tilesImageLarge := rl.GenImageColor(int(9 * largeTileSize), int(largeRows * largeTileSize), rl.White)
 
     textures.smallTileSize = int(smallTileSize)
     textures.largeTileSize = int(largeTileSize)
@@ -508,17 +941,18 @@ func updateTextures(textures *Textures, wndWidth, wndHeight, oldTileSize int32)
     textures.tileCursor = rl.LoadTextureFromImage(tileCursorImage)
     rl.UnloadImage(tileCursorImage)
 
-    scores := getLetterScores()
-    for i := int32(0); i < 26; i++ {
-        renderTile(tilesImageSmall, textures.letterGlyphsSmall, textures.numberGlyphsSmall, i, int32(scores[i]), smallTileSize)
-        renderTile(tilesImageSmall, textures.letterGlyphsSmall, textures.numberGlyphsSmall, 27 + i, 0, smallTileSize)
-        renderTile(tilesImageLarge, textures.letterGlyphsLarge, textures.numberGlyphsLarge, i, int32(scores[i]), largeTileSize)
+    for i := int32(0); i < nLetters; i++ {
+        letter := game.rules.TileSet[i].Letter
+        points := game.rules.TileSet[i].Points
+        renderTile(tilesImageSmall, textures.letterGlyphsSmall, textures.numberGlyphsSmall, textures.letterGlyphIndex, letter, i, points, false, smallTileSize)
+        renderTile(tilesImageSmall, textures.letterGlyphsSmall, textures.numberGlyphsSmall, textures.letterGlyphIndex, letter, nLetters + 1 + i, 0, true, smallTileSize)
+        renderTile(tilesImageLarge, textures.letterGlyphsLarge, textures.numberGlyphsLarge, textures.letterGlyphIndex, letter, i, points, false, largeTileSize)
     }
 
     tdsIntSmall := int(smallTileSize)
     tdsIntLarge := int(largeTileSize)
-    roundTileEdges(tilesImageSmall.Data, 9, 6, tdsIntSmall, tdsIntSmall, int(float64(tdsIntSmall) * 0.15))
-    roundTileEdges(tilesImageLarge.Data, 9, 3, tdsIntLarge, tdsIntLarge, int(float64(tdsIntLarge) * 0.15))
+    roundTileEdges(tilesImageSmall.Data, 9, int(smallRows), tdsIntSmall, tdsIntSmall, int(float64(tdsIntSmall) * 0.15))
+    roundTileEdges(tilesImageLarge.Data, 9, int(largeRows), tdsIntLarge, tdsIntLarge, int(float64(tdsIntLarge) * 0.15))
 
     if textures.tilesSmall.ID > 0 {
         rl.UnloadTexture(textures.tilesSmall)
@@ -535,26 +969,43 @@ func updateTextures(textures *Textures, wndWidth, wndHeight, oldTileSize int32)
     return tileSize
 }
 
-func renderTile(tilesImage *rl.Image, letterGlyphs, numberGlyphs []rl.GlyphInfo, idx, points, tileSize int32) {
+// isBlank is for a blank that has been given a letter, since a letter in the tile set can be worth nothing too
+func renderTile(tilesImage *rl.Image, letterGlyphs, numberGlyphs []rl.GlyphInfo, glyphIndex map[rune]int, text string, idx, points int32, isBlank bool, tileSize int32) {
     srcRect := rl.Rectangle{}
     dstRect := rl.Rectangle{}
-    letter := &letterGlyphs[idx % 27].Image
-    lift := -float32(letter.Height) * 0.15
 
-    dstRect.X = float32(((idx % 9) * tileSize) + (tileSize - letter.Width) / 2)
-    dstRect.Y = lift + float32(((idx / 9) * tileSize) + (tileSize - letter.Height) / 2)
-    dstRect.Width  = float32(letter.Width)
-    dstRect.Height = float32(letter.Height)
-    srcRect.Width  = dstRect.Width
-    srcRect.Height = dstRect.Height
+    // letters made of several characters are squeezed side by side so they still fit on one tile
+    chars := []rune(text)
+    scale := min(float32(1.0), 1.3 / float32(len(chars)))
+    width := float32(0.0)
+    for _, char := range chars {
+        width += float32(letterGlyphs[glyphIndex[char]].Image.Width) * scale
+    }
+
+    letterColor := rl.Black
+    if isBlank {
+        letterColor = blankLetterColor
+    }
+
+    x := float32((idx % 9) * tileSize) + (float32(tileSize) - width) / 2
+    for _, char := range chars {
+        letter := &letterGlyphs[glyphIndex[char]].Image
+        lift := -float32(letter.Height) * 0.15 * scale
+
+        srcRect.Width  = float32(letter.Width)
+        srcRect.Height = float32(letter.Height)
+        dstRect.Width  = srcRect.Width * scale
+        dstRect.Height = srcRect.Height * scale
+        dstRect.X = x
+        dstRect.Y = lift + float32((idx / 9) * tileSize) + (float32(tileSize) - dstRect.Height) / 2
+        rl.ImageDraw(tilesImage, letter, srcRect, dstRect, letterColor)
+        x += dstRect.Width
+    }
 
     if points <= 0 {
-        rl.ImageDraw(tilesImage, letter, srcRect, dstRect, rl.Gray)
         return
     }
 
-    rl.ImageDraw(tilesImage, letter, srcRect, dstRect, rl.Black)
-
     number := &numberGlyphs[points % 10].Image
     corner := 2 * tileSize / 3
     dstRect.X = float32(((idx % 9) * tileSize) + corner + (tileSize / 3 - number.Width) / 2)
@@ -650,8 +1101,48 @@ func main() {
 	}
 	defer saveConfig(&config)
 
+	// the seed given on the command line is only for this run, so it isn't saved to the config
+	seed := flag.Int("seed", config.SeedInt, "the seed for drawing tiles, which replays the same draws given the same moves")
+	flag.Parse()
+
+	tileSet := engine.DefaultTiles[:]
+	if assets.TileSet != nil {
+		tileSet, err = engine.ParseTileSet(assets.TileSet)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
 	game := Game{}
-	game.init(assets.WordList, time.Now().UnixMilli())
+	game.init(assets.WordList, tileSet, time.Now().UnixMilli())
+	if assets.BoardLayout != nil {
+		layout, err := engine.ParseBoardLayout(assets.BoardLayout)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		game.rules.SetLayout(layout)
+	}
+	if assets.Replay != nil {
+		err = game.loadReplay(assets.Replay)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+	game.menu.gameMode = getGameMode(config.GameMode)
+	game.menu.shouldValidateEveryWord = game.menu.gameMode == MODE_AUTOMATIC || game.menu.gameMode == MODE_XRAY
+	game.menu.challengeRule = getChallengeRule(config.ChallengeRule)
+	game.menu.challengePoints = config.ChallengePointsInt
+	game.menu.clockMode = getClockMode(config.ClockMode)
+	game.menu.timeLimitSecs = config.TimeLimitSecondsInt
+	game.menu.incrementSecs = config.IncrementSecondsInt
+	game.menu.overtimePenalty = config.OvertimePenaltyInt
+	game.menu.scorelessTurnLimit = config.ScorelessTurnsInt
+	game.menu.rackSize = min(max(config.RackSizeInt, engine.MIN_RACK_SIZE), engine.MAX_RACK_SIZE)
+	game.menu.seed = *seed
+	game.hasAutosave = len(findAutosaves()) > 0
 
 	rl.SetConfigFlags(rl.FlagWindowResizable)
 	rl.InitWindow(800, 450, "scrambles")
@@ -677,7 +1168,7 @@ func main() {
 		if game.wndWidth != w || game.wndHeight != h {
 			game.wndWidth = w
 			game.wndHeight = h
-			game.tileSize = updateTextures(&textures, w, h, game.tileSize)
+			game.tileSize = updateTextures(&game, &textures, w, h, game.tileSize)
 		}
 
         updateInputs(&inputs)
@@ -687,10 +1178,26 @@ func main() {
 
 	    tBoardFall := float32(1.0)
 	    if !gameStarted || openingTimer < maxOpeningTime {
-		    if drawMenu(&game, &inputs, openingTimer == 0) {
+		    choice := drawMenu(&game, &inputs, openingTimer == 0)
+		    if choice == MENU_NEW_GAME {
 				game.start()
 				isGameOver = false
 				gameStarted = true
+			} else if choice == MENU_LOAD_GAME || choice == MENU_RESUME_GAME || choice == MENU_WATCH_REPLAY {
+				var err error
+				if choice == MENU_LOAD_GAME {
+					err = game.loadGame(SAVE_FILE)
+				} else if choice == MENU_RESUME_GAME {
+					err = game.loadAutosave()
+				} else {
+					err = game.startReplay()
+				}
+				if err != nil {
+					game.showMessage(err.Error())
+				} else {
+					isGameOver = false
+					gameStarted = true
+				}
 			}
 			tBoardFall = float32(openingTimer) / float32(maxOpeningTime)
 	    }
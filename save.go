@@ -0,0 +1,263 @@
+package main
+
+import "errors"
+import "os"
+import "sort"
+import "strconv"
+import "strings"
+import "scrambles/engine"
+
+// a saved game starts with the version of the format, then the menu settings, the players and their clocks,
+// then the state of the rules. it's only written at the start of a turn, once any tiles have gone back on the rack
+const SAVE_VERSION = 1
+const SAVE_FILE = "saved-game.txt"
+
+var saveMigrations = [SAVE_VERSION - 1]engine.Migration{}
+
+// the game is also saved after every turn, taking turns between a few files so that
+// there's still a good one if the game stops while one is being written
+const AUTOSAVE_SLOTS = 3
+
+func writeSaveLine(builder *strings.Builder, name string, values ...int) {
+    builder.WriteString(name)
+    for _, v := range values {
+        builder.WriteString(" " + strconv.Itoa(v))
+    }
+    builder.WriteString("\n")
+}
+
+func (game *Game) writeSave() string {
+    var builder strings.Builder
+    writeSaveLine(&builder, "Version", SAVE_VERSION)
+    writeSaveLine(&builder, "GameMode", int(game.menu.gameMode))
+    writeSaveLine(&builder, "ChallengeRule", int(game.menu.challengeRule))
+    writeSaveLine(&builder, "ChallengePoints", game.menu.challengePoints)
+    writeSaveLine(&builder, "ClockMode", int(game.menu.clockMode))
+    writeSaveLine(&builder, "TimeLimitSeconds", game.menu.timeLimitSecs)
+    writeSaveLine(&builder, "IncrementSeconds", game.menu.incrementSecs)
+    writeSaveLine(&builder, "OvertimePenalty", game.menu.overtimePenalty)
+    writeSaveLine(&builder, "ScorelessTurnLimit", game.menu.scorelessTurnLimit)
+    writeSaveLine(&builder, "RackSize", game.menu.rackSize)
+
+    validate := 0
+    if game.menu.shouldValidateEveryWord {
+        validate = 1
+    }
+    writeSaveLine(&builder, "ValidateEveryWord", validate)
+    writeSaveLine(&builder, "State", int(game.state.cur))
+    for i := 0; i < 4; i++ {
+        p := &game.players[i]
+        writeSaveLine(&builder, "Player" + strconv.Itoa(i + 1), int(p.kind), framesToMillis(p.clockFrames), framesToMillis(p.overtimeFrames))
+    }
+
+    builder.WriteString(game.rules.WriteState())
+    return builder.String()
+}
+
+func (game *Game) saveGame(fileName string) error {
+    mode := int32(game.state.cur) & ^3
+    if mode != PLAYER_TURN || game.isReplay {
+        return errors.New("A game can only be saved during a turn")
+    }
+
+    p := &game.players[game.state.cur & 3]
+    game.isExchanging = false
+    game.exchangeSlots = 0
+    game.recallTiles(p)
+
+    err := os.WriteFile(fileName, []byte(game.writeSave()), 0666)
+    if err != nil {
+        return errors.New("Couldn't save the game to " + fileName)
+    }
+    return nil
+}
+
+// only the lines that are all numbers are settings, the rest of the file is left for the rules to read
+func readSaveValues(lines []string) map[string][]int {
+    values := make(map[string][]int)
+    for _, l := range lines {
+        fields := strings.Fields(l)
+        if len(fields) < 2 {
+            continue
+        }
+        var numbers []int
+        for _, f := range fields[1:] {
+            n, err := strconv.Atoi(f)
+            if err != nil {
+                numbers = nil
+                break
+            }
+            numbers = append(numbers, n)
+        }
+        if numbers != nil {
+            values[fields[0]] = numbers
+        }
+    }
+    return values
+}
+
+func getSaveValues(values map[string][]int, name string, count int) ([]int, error) {
+    v := values[name]
+    if len(v) != count {
+        return nil, errors.New("The saved game should have " + strconv.Itoa(count) + " number(s) for " + name)
+    }
+    return v, nil
+}
+
+func (game *Game) loadGame(fileName string) error {
+    data, err := os.ReadFile(fileName)
+    if err != nil {
+        return errors.New("Couldn't open the saved game " + fileName)
+    }
+    lines := strings.Split(string(data), "\n")
+    version, err := engine.FindVersion(lines, "Version ")
+    if err != nil || version == 0 {
+        return errors.New(fileName + " isn't a saved game")
+    }
+    lines, err = engine.Migrate("saved game " + fileName, lines, version, saveMigrations[:])
+    if err != nil {
+        return err
+    }
+    values := readSaveValues(lines)
+
+    var settings [11]int
+    names := [...]string{"GameMode", "ChallengeRule", "ChallengePoints", "ClockMode", "TimeLimitSeconds", "IncrementSeconds",
+        "OvertimePenalty", "ScorelessTurnLimit", "RackSize", "ValidateEveryWord", "State"}
+    for i, name := range names {
+        v, err := getSaveValues(values, name, 1)
+        if err != nil {
+            return err
+        }
+        settings[i] = v[0]
+    }
+    if settings[8] < engine.MIN_RACK_SIZE || settings[8] > engine.MAX_RACK_SIZE {
+        return errors.New("The saved game has a rack size of " + strconv.Itoa(settings[8]))
+    }
+    if int32(settings[10]) & ^3 != PLAYER_TURN {
+        return errors.New("The saved game should start at the beginning of a turn")
+    }
+
+    var players [4][]int
+    for i := 0; i < 4; i++ {
+        players[i], err = getSaveValues(values, "Player" + strconv.Itoa(i + 1), 3)
+        if err != nil {
+            return err
+        }
+    }
+
+    // the rules are only changed if the saved game can be read, so the old settings are put back if it can't
+    menu := game.menu
+    game.menu.gameMode = int32(settings[0])
+    game.menu.challengeRule = int32(settings[1])
+    game.menu.challengePoints = settings[2]
+    game.menu.clockMode = int32(settings[3])
+    game.menu.timeLimitSecs = settings[4]
+    game.menu.incrementSecs = settings[5]
+    game.menu.overtimePenalty = settings[6]
+    game.menu.scorelessTurnLimit = settings[7]
+    game.menu.rackSize = settings[8]
+    game.menu.shouldValidateEveryWord = settings[9] != 0
+    game.applySettings()
+
+    err = game.rules.ReadState(lines)
+    if err != nil {
+        game.menu = menu
+        game.applySettings()
+        return err
+    }
+
+    game.isReplay = false
+    game.isAutosaveCurrent = false
+    game.isAutosaveFailing = false
+    game.clearTurns()
+    for i := 0; i < 4; i++ {
+        p := &game.players[i]
+        p.kind = int32(players[i][0])
+        if !game.rules.IsActive[i] {
+            p.kind = PLAYER_INACTIVE
+        } else if p.kind == PLAYER_INACTIVE {
+            p.kind = PLAYER_REAL
+        }
+        p.clockFrames = millisToFrames(players[i][1])
+        p.overtimeFrames = millisToFrames(players[i][2])
+        p.deckTilesBits.cur = makeRack(game.rules.Racks[i])
+        p.deckTilesBits.prev = p.deckTilesBits.cur
+    }
+    game.updateScores()
+
+    game.state.prev = 0
+    game.state.cur = uint64(PLAYER_TURN | game.rules.Turn)
+    game.state.animPos = 0
+    game.state.animLen = 80
+    return nil
+}
+
+func getAutosaveFile(slot int) string {
+    return "autosave-" + strconv.Itoa(slot + 1) + ".txt"
+}
+
+// the newest autosave comes first
+func findAutosaves() []int {
+    var slots []int
+    var times [AUTOSAVE_SLOTS]int64
+    for i := 0; i < AUTOSAVE_SLOTS; i++ {
+        info, err := os.Stat(getAutosaveFile(i))
+        if err == nil {
+            slots = append(slots, i)
+            times[i] = info.ModTime().UnixNano()
+        }
+    }
+    sort.SliceStable(slots, func(a, b int) bool {
+        return times[slots[a]] > times[slots[b]]
+    })
+    return slots
+}
+
+func (game *Game) autosave() {
+    game.autosaveSlot = (game.autosaveSlot + 1) % AUTOSAVE_SLOTS
+    err := game.saveGame(getAutosaveFile(game.autosaveSlot))
+    if err != nil {
+        // the message for the turn is kept, and the failure is only reported once until a save works again
+        if !game.isAutosaveFailing && game.messageTimer > 0 {
+            game.showMessage(game.message + ". " + err.Error())
+        } else if !game.isAutosaveFailing {
+            game.showMessage(err.Error())
+        }
+        game.isAutosaveFailing = true
+        return
+    }
+    game.isAutosaveFailing = false
+
+    // the last unfinished game can still be resumed until this one has been saved in its place
+    if !game.isAutosaveCurrent {
+        for i := 0; i < AUTOSAVE_SLOTS; i++ {
+            if i != game.autosaveSlot {
+                os.Remove(getAutosaveFile(i))
+            }
+        }
+        game.isAutosaveCurrent = true
+    }
+    game.hasAutosave = true
+}
+
+// falls back to an older autosave if the newest one can't be read
+func (game *Game) loadAutosave() error {
+    err := errors.New("There's no unfinished game to resume")
+    for _, slot := range findAutosaves() {
+        err = game.loadGame(getAutosaveFile(slot))
+        if err == nil {
+            game.autosaveSlot = slot
+            game.isAutosaveCurrent = true
+            return nil
+        }
+    }
+    return err
+}
+
+// once a game is over there's nothing left to resume
+func (game *Game) removeAutosaves() {
+    for i := 0; i < AUTOSAVE_SLOTS; i++ {
+        os.Remove(getAutosaveFile(i))
+    }
+    game.hasAutosave = false
+}